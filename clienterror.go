@@ -0,0 +1,119 @@
+package httptrace
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+const (
+	errorKindTag         = "error.kind"
+	errorMessageTag      = "error.message"
+	deadlineRemainingTag = "deadline.remaining_ms"
+
+	errorKindCanceled          = "canceled"
+	errorKindTimeout           = "timeout"
+	errorKindConnectionRefused = "connection_refused"
+	errorKindDNS               = "dns"
+	errorKindTLS               = "tls"
+	errorKindOther             = "other"
+
+	// tlsRemoteAlert and tlsLocalAlert are the operations of the net.OpError
+	// returned by crypto/tls for alerts received from and sent to the peer.
+	tlsRemoteAlert = "remote error"
+	tlsLocalAlert  = "local error"
+	tlsErrorPrefix = "tls: "
+)
+
+// tagDeadline records the time remaining before the deadline of the context,
+// if it has one, when the request is started.
+func tagDeadline(ctx context.Context, span opentracing.Span) {
+	if deadline, ok := ctx.Deadline(); ok {
+		span.SetTag(deadlineRemainingTag, time.Until(deadline).Milliseconds())
+	}
+}
+
+// tagClientError records the classification and message of the error that
+// ended an outgoing request.
+func tagClientError(span opentracing.Span, err error) {
+	span.SetTag(errorKindTag, classifyClientError(err))
+	span.SetTag(errorMessageTag, err.Error())
+}
+
+// classifyClientError returns the kind of failure that caused the error of an
+// outgoing request. Context errors are checked first because the network
+// errors they cause are only a symptom of the request being abandoned.
+func classifyClientError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return errorKindCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return errorKindTimeout
+	case errors.As(err, &dnsErr):
+		return errorKindDNS
+	case isTLSError(err):
+		return errorKindTLS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errorKindConnectionRefused
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return errorKindTimeout
+	}
+	return errorKindOther
+}
+
+// isTLSError reports whether the error is a failed TLS handshake or a rejected
+// certificate. Alerts sent or received during the handshake, such as for a
+// protocol version that is not supported, are only exposed by the crypto/tls
+// package as a net.OpError with an operation naming the side that raised the
+// alert. Other handshake failures are only identified by their message.
+func isTLSError(err error) bool {
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) ||
+		errors.As(err, &alertErr) ||
+		errors.As(err, &verifyErr) ||
+		errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr) {
+		return true
+	}
+	return hasTLSCause(err)
+}
+
+// hasTLSCause reports whether the error, or any error it wraps, is a TLS alert
+// or an error raised by the crypto/tls package.
+func hasTLSCause(err error) bool {
+	if err == nil {
+		return false
+	}
+	if opErr, ok := err.(*net.OpError); ok && (opErr.Op == tlsRemoteAlert || opErr.Op == tlsLocalAlert) {
+		return true
+	}
+	if strings.HasPrefix(err.Error(), tlsErrorPrefix) {
+		return true
+	}
+	switch wrapped := err.(type) {
+	case interface{ Unwrap() error }:
+		return hasTLSCause(wrapped.Unwrap())
+	case interface{ Unwrap() []error }:
+		for _, e := range wrapped.Unwrap() {
+			if hasTLSCause(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
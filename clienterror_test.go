@@ -0,0 +1,124 @@
+package httptrace
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyClientError(t *testing.T) {
+	var tests = []struct {
+		name string
+		err  error
+		kind string
+	}{
+		{"canceled", fmt.Errorf("request: %w", context.Canceled), errorKindCanceled},
+		{"deadline", &net.OpError{Op: "dial", Err: context.DeadlineExceeded}, errorKindTimeout},
+		{"net timeout", &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, errorKindTimeout},
+		{"refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, errorKindConnectionRefused},
+		{"dns", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}}, errorKindDNS},
+		{"tls", fmt.Errorf("handshake: %w", x509.UnknownAuthorityError{}), errorKindTLS},
+		{"tls remote alert", &net.OpError{Op: "remote error", Err: errors.New("tls: protocol version not supported")}, errorKindTLS},
+		{"tls local alert", &net.OpError{Op: "local error", Err: errors.New("tls: handshake failure")}, errorKindTLS},
+		{"tls handshake", fmt.Errorf("request: %w", errors.New("tls: server selected unsupported protocol version 301")), errorKindTLS},
+		{"other", errors.New("failure"), errorKindOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if kind := classifyClientError(tt.err); kind != tt.kind {
+				t.Errorf("expected %s but got %s", tt.kind, kind)
+			}
+		})
+	}
+}
+
+func TestTransportTagsClientErrors(t *testing.T) {
+	var listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refused = "http://" + listener.Addr().String() + "/"
+	_ = listener.Close()
+	var server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ErrorLog = stdlog.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	// The version mismatch is made with a server whose certificate is trusted
+	// so that only the handshake can fail.
+	var modern = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	modern.Config.ErrorLog = stdlog.New(io.Discard, "", 0)
+	modern.TLS = &tls.Config{MinVersion: tls.VersionTLS13}
+	modern.StartTLS()
+	defer modern.Close()
+	var legacyTLS = modern.Client().Transport.(*http.Transport).Clone()
+	legacyTLS.TLSClientConfig.MaxVersion = tls.VersionTLS12
+
+	var tests = []struct {
+		name      string
+		url       string
+		transport http.RoundTripper
+		kind      string
+	}{
+		{"refused", refused, &http.Transport{}, errorKindConnectionRefused},
+		{"unknown authority", server.URL, &http.Transport{}, errorKindTLS},
+		{"version mismatch", modern.URL, legacyTLS, errorKindTLS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var recorder = NewRecorder()
+			var tracer, _ = NewTracer(nil, "client", "127.0.0.1:8080", TracerOptionReporter(recorder))
+			var parent = tracer.StartSpan("parent")
+			var client = &http.Client{Transport: NewTransport(TransportOptionSpanName("TESTSPAN"))(tt.transport)}
+			var ctx, cancel = context.WithTimeout(ContextWithSpan(context.Background(), parent), time.Minute)
+			defer cancel()
+			var r, _ = http.NewRequest(http.MethodGet, tt.url, nil)
+			if _, err := client.Do(r.WithContext(ctx)); err == nil {
+				t.Fatal("expected the request to fail")
+			}
+			parent.Finish()
+
+			var spans = recorder.SpansNamed("TESTSPAN")
+			if len(spans) != 1 {
+				t.Fatalf("expected 1 client span but got %d", len(spans))
+			}
+			recorder.AssertTag(t, spans[0], "error", "true")
+			recorder.AssertTag(t, spans[0], errorKindTag, tt.kind)
+			if spans[0].Tags[errorMessageTag] == "" {
+				t.Error("client span is missing the error message")
+			}
+			if spans[0].Tags[deadlineRemainingTag] == "" {
+				t.Error("client span is missing the remaining deadline")
+			}
+		})
+	}
+}
+
+func TestTransportLegacyOmitsClientErrorTags(t *testing.T) {
+	var recorder = NewRecorder()
+	var tracer, _ = NewTracer(nil, "client", "127.0.0.1:8080", TracerOptionReporter(recorder), TracerOptionLegacyFormat(true))
+	var parent = tracer.StartSpan("parent")
+	var wrapped = NewTransport(TransportOptionSpanName("TESTSPAN"))(&fixtureTransport{Err: context.DeadlineExceeded})
+	var ctx, cancel = context.WithTimeout(ContextWithSpan(context.Background(), parent), time.Minute)
+	defer cancel()
+	var r, _ = http.NewRequest(http.MethodGet, "http://localhost/", nil)
+	_, _ = wrapped.RoundTrip(r.WithContext(ctx))
+	parent.Finish()
+
+	var span = recorder.SpansNamed("TESTSPAN")[0]
+	for _, tag := range []string{errorKindTag, errorMessageTag, deadlineRemainingTag} {
+		if _, ok := span.Tags[tag]; ok {
+			t.Errorf("legacy client span was tagged with %s", tag)
+		}
+	}
+}
@@ -3,80 +3,317 @@ package httptrace
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/asecurityteam/logevent"
-	"github.com/openzipkin/zipkin-go-opentracing/thrift/gen-go/zipkincore"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/openzipkin/zipkin-go/model"
 )
 
-// collector implements the openzipkin Collector interface.
+const (
+	defaultSpanMessage = "span-complete"
+	defaultSpanKey     = "zipkin"
+	defaultSpanLevel   = "info"
+)
+
+var errNilLogger = errors.New("a Logger is required to emit spans to the logs")
+
+// collector implements the zipkin-go Reporter interface by emitting each span
+// as a log event.
 type collector struct {
 	logevent.Logger
+	legacy bool
+	// emit is the logger method that matches the configured level.
+	emit func(event interface{})
+	// event renders the span portion of a frame into a log event. It is nil
+	// when the default message and key are used.
+	event func(span interface{}) interface{}
 }
 
-func (c *collector) Collect(s *zipkincore.Span) error {
-	var zipkinLog = structFromSpan(s)
-	c.Info(zipkinLog)
-	return nil
+// newCollector creates a collector that emits spans as configured by the
+// tracer options. An error is returned if the logger is nil.
+func newCollector(logger logevent.Logger, t *tracer) (*collector, error) {
+	if logger == nil {
+		return nil, errNilLogger
+	}
+	var c = &collector{Logger: logger, legacy: t.legacyFormat}
+	switch strings.ToLower(t.logLevel) {
+	case "debug":
+		c.emit = logger.Debug
+	case "info":
+		c.emit = logger.Info
+	case "warn":
+		c.emit = logger.Warn
+	case "error":
+		c.emit = logger.Error
+	default:
+		return nil, fmt.Errorf("unknown span log level %q", t.logLevel)
+	}
+	if t.logMessage == defaultSpanMessage && t.logSpanKey == defaultSpanKey && !t.logFlatten {
+		return c, nil
+	}
+	var spanType = reflect.TypeOf(v2Span{})
+	if c.legacy {
+		spanType = reflect.TypeOf(jsonSpan{})
+	}
+	c.event = frameBuilder(spanType, t.logMessage, t.logSpanKey, t.logFlatten)
+	return c, nil
 }
 
-func (c *collector) Close() error {
-	return nil
+func (c *collector) Send(s model.SpanModel) {
+	c.SendWithLogs(s, nil)
 }
 
-func structFromSpan(s *zipkincore.Span) frame {
-	var result = frame{}
-
-	result.Zipkin.TraceID = fmt.Sprintf("%016x", s.GetTraceID())
-	result.Zipkin.SpanID = fmt.Sprintf("%016x", s.GetID())
-	if s.IsSetParentID() {
-		result.Zipkin.ParentID = fmt.Sprintf("%016x", s.GetParentID())
-	}
-	if s.IsSetDuration() {
-		result.Zipkin.Duration = s.GetDuration()
+// SendWithLogs emits the span with the fields of its logs.
+func (c *collector) SendWithLogs(s model.SpanModel, logs [][]log.Field) {
+	var span interface{}
+	var event interface{}
+	if c.legacy {
+		var f = legacyFrameFromSpan(s)
+		span, event = f.Zipkin, f
+	} else {
+		var f = frameFromSpan(s, logs)
+		span, event = f.Zipkin, f
 	}
-	if s.IsSetTimestamp() {
-		result.Zipkin.Timestamp = s.GetTimestamp()
+	if c.event != nil {
+		event = c.event(span)
 	}
-	result.Zipkin.Name = s.GetName()
-	var annotations = s.GetAnnotations()
-	var binaryAnnotations = s.GetBinaryAnnotations()
-	result.Zipkin.Annotations = make([]annotation, len(annotations))
-	result.Zipkin.BinaryAnnotations = make([]binaryAnnotation, len(binaryAnnotations))
+	c.emit(event)
+}
+
+func (c *collector) Close() error {
+	return nil
+}
 
-	for offset, an := range annotations {
-		var host = an.GetHost()
-		result.Zipkin.Annotations[offset] = annotation{
-			Timestamp: an.GetTimestamp(),
-			Value:     an.GetValue(),
+// frameBuilder returns a function that renders spans of the given type into a
+// log event with a custom message and span key. Log events are structs whose
+// field names come from struct tags so the event type is generated once with
+// the configured names. When flatten is set the fields of the span are placed
+// at the top level of the event rather than under the span key.
+func frameBuilder(spanType reflect.Type, message string, key string, flatten bool) func(interface{}) interface{} {
+	var fields []reflect.StructField
+	if flatten {
+		for offset := 0; offset < spanType.NumField(); offset = offset + 1 {
+			fields = append(fields, spanType.Field(offset))
 		}
-		if host != nil {
-			var ip = make(net.IP, 4)
-			binary.BigEndian.PutUint32(ip, uint32(host.GetIpv4()))
-			result.Zipkin.Annotations[offset].Endpoint = endpoint{
-				Ipv4:        netIP(ip),
-				Port:        int(host.GetPort()),
-				ServiceName: host.GetServiceName(),
+	} else {
+		fields = append(fields, reflect.StructField{
+			Name: "Span",
+			Type: spanType,
+			Tag:  reflect.StructTag(fmt.Sprintf(`logevent:"%s"`, key)),
+		})
+	}
+	fields = append(fields, reflect.StructField{
+		Name: "Message",
+		Type: reflect.TypeOf(""),
+		Tag:  `logevent:"message"`,
+	})
+	var eventType = reflect.StructOf(fields)
+	return func(span interface{}) interface{} {
+		var event = reflect.New(eventType).Elem()
+		var value = reflect.ValueOf(span)
+		if flatten {
+			for offset := 0; offset < value.NumField(); offset = offset + 1 {
+				event.Field(offset).Set(value.Field(offset))
 			}
+		} else {
+			event.Field(0).Set(value)
 		}
+		event.FieldByName("Message").SetString(message)
+		return event.Interface()
 	}
-	for offset, binan := range binaryAnnotations {
-		var host = binan.GetHost()
-		result.Zipkin.BinaryAnnotations[offset] = binaryAnnotation{
-			Key:   binan.GetKey(),
-			Value: string(binan.GetValue()),
+}
+
+func frameFromSpan(s model.SpanModel, logs [][]log.Field) v2Frame {
+	var result = v2Frame{}
+
+	result.Zipkin.TraceID = s.TraceID.String()
+	result.Zipkin.SpanID = s.ID.String()
+	if s.ParentID != nil {
+		result.Zipkin.ParentID = s.ParentID.String()
+	}
+	result.Zipkin.Name = s.Name
+	result.Zipkin.Kind = string(s.Kind)
+	result.Zipkin.Timestamp = microseconds(s.Timestamp)
+	result.Zipkin.Duration = durationMicroseconds(s.Duration)
+	result.Zipkin.LocalEndpoint = v2EndpointFromModel(s.LocalEndpoint)
+	result.Zipkin.RemoteEndpoint = v2EndpointFromModel(s.RemoteEndpoint)
+	result.Zipkin.Annotations = make([]v2Annotation, len(s.Annotations))
+	for offset, an := range s.Annotations {
+		result.Zipkin.Annotations[offset] = v2Annotation{
+			Timestamp: microseconds(an.Timestamp),
+			Value:     an.Value,
 		}
-		if host != nil {
-			var ip = make(net.IP, 4)
-			binary.BigEndian.PutUint32(ip, uint32(host.GetIpv4()))
-			result.Zipkin.BinaryAnnotations[offset].Endpoint = endpoint{
-				Ipv4:        netIP(ip),
-				Port:        int(host.GetPort()),
-				ServiceName: host.GetServiceName(),
-			}
+		if offset < len(logs) {
+			result.Zipkin.Annotations[offset].Fields = logFields(logs[offset])
 		}
 	}
+	result.Zipkin.Tags = make(map[string]string, len(s.Tags))
+	for k, v := range s.Tags {
+		result.Zipkin.Tags[k] = v
+	}
+	return result
+}
+
+// logFields returns the fields of a span log recorded with LogFields or LogKV
+// with their original types. A log made of a single event, which is recorded
+// as the plain value of the event, has no fields.
+func logFields(fields []log.Field) map[string]interface{} {
+	if len(fields) < 1 || (len(fields) == 1 && fields[0].Key() == "event") {
+		return nil
+	}
+	var values = make(fieldValues, len(fields))
+	for _, field := range fields {
+		field.Marshal(values)
+	}
+	return values
+}
+
+// fieldValues implements the opentracing log.Encoder interface by collecting
+// the value of each field.
+type fieldValues map[string]interface{}
+
+func (f fieldValues) EmitString(key, value string)             { f[key] = value }
+func (f fieldValues) EmitBool(key string, value bool)          { f[key] = value }
+func (f fieldValues) EmitInt(key string, value int)            { f[key] = value }
+func (f fieldValues) EmitInt32(key string, value int32)        { f[key] = value }
+func (f fieldValues) EmitInt64(key string, value int64)        { f[key] = value }
+func (f fieldValues) EmitUint32(key string, value uint32)      { f[key] = value }
+func (f fieldValues) EmitUint64(key string, value uint64)      { f[key] = value }
+func (f fieldValues) EmitFloat32(key string, value float32)    { f.emitFloat(key, float64(value)) }
+func (f fieldValues) EmitFloat64(key string, value float64)    { f.emitFloat(key, value) }
+func (f fieldValues) EmitObject(key string, value interface{}) { f[key] = value }
+func (f fieldValues) EmitLazyLogger(value log.LazyLogger)      { value(f) }
+
+// emitFloat records values that cannot be represented in JSON as text.
+func (f fieldValues) emitFloat(key string, value float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		f[key] = strconv.FormatFloat(value, 'g', -1, 64)
+		return
+	}
+	f[key] = value
+}
+
+func v2EndpointFromModel(e *model.Endpoint) v2Endpoint {
+	if e == nil {
+		return v2Endpoint{}
+	}
+	var result = v2Endpoint{
+		ServiceName: e.ServiceName,
+		Port:        int(e.Port),
+	}
+	if len(e.IPv4) > 0 {
+		result.Ipv4 = e.IPv4.String()
+	}
+	if len(e.IPv6) > 0 {
+		result.Ipv6 = e.IPv6.String()
+	}
+	return result
+}
+
+// legacyFrameFromSpan renders a span exactly as the zipkin-go-opentracing
+// recorder and the previous collector did. The V2 kind of the span is
+// converted back into core annotations and the span.kind and peer.service
+// tags are restored from the kind and remote endpoint.
+func legacyFrameFromSpan(s model.SpanModel) frame {
+	var result = frame{}
+
+	result.Zipkin.TraceID = fmt.Sprintf("%016x", s.TraceID.Low)
+	result.Zipkin.SpanID = fmt.Sprintf("%016x", uint64(s.ID))
+	if s.ParentID != nil {
+		result.Zipkin.ParentID = fmt.Sprintf("%016x", uint64(*s.ParentID))
+	}
+	result.Zipkin.Duration = durationMicroseconds(s.Duration)
+	result.Zipkin.Timestamp = microseconds(s.Timestamp)
+	result.Zipkin.Name = s.Name
+
+	var host = legacyEndpointFromModel(s.LocalEndpoint)
+	var annotations = make([]annotation, 0, len(s.Annotations)+2)
+	var binaryAnnotations = make([]binaryAnnotation, 0, len(s.Tags)+3)
+	switch s.Kind {
+	case model.Client:
+		annotations = append(annotations,
+			annotation{Timestamp: microseconds(s.Timestamp), Value: "cs", Endpoint: host},
+			annotation{Timestamp: microseconds(s.Timestamp.Add(s.Duration)), Value: "cr", Endpoint: host},
+		)
+	case model.Server:
+		annotations = append(annotations,
+			annotation{Timestamp: microseconds(s.Timestamp), Value: "sr", Endpoint: host},
+			annotation{Timestamp: microseconds(s.Timestamp.Add(s.Duration)), Value: "ss", Endpoint: host},
+		)
+	default:
+		binaryAnnotations = append(binaryAnnotations, binaryAnnotation{Key: "lc", Value: host.ServiceName, Endpoint: host})
+	}
+	for _, an := range s.Annotations {
+		annotations = append(annotations, annotation{
+			Timestamp: microseconds(an.Timestamp),
+			Value:     an.Value,
+			Endpoint:  host,
+		})
+	}
+
+	var tags = make(map[string]string, len(s.Tags)+2)
+	for k, v := range s.Tags {
+		tags[k] = v
+	}
+	if s.Kind != "" {
+		tags["span.kind"] = strings.ToLower(string(s.Kind))
+	}
+	if s.RemoteEndpoint != nil && s.RemoteEndpoint.ServiceName != "" {
+		tags["peer.service"] = s.RemoteEndpoint.ServiceName
+	}
+	var keys = make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		binaryAnnotations = append(binaryAnnotations, binaryAnnotation{Key: k, Value: tags[k], Endpoint: host})
+	}
+
+	result.Zipkin.Annotations = annotations
+	result.Zipkin.BinaryAnnotations = binaryAnnotations
+	return result
+}
+
+// legacyEndpointFromModel converts an endpoint into the form produced from
+// the thrift model, including the representation of the port as an int16.
+func legacyEndpointFromModel(e *model.Endpoint) endpoint {
+	var ip = make(net.IP, 4)
+	if e == nil {
+		return endpoint{Ipv4: netIP(ip)}
+	}
+	if ipv4 := e.IPv4.To4(); ipv4 != nil {
+		binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(ipv4))
+	}
+	return endpoint{
+		ServiceName: e.ServiceName,
+		Port:        int(int16(e.Port)),
+		Ipv4:        netIP(ip),
+	}
+}
+
+// microseconds converts a time into microseconds since the epoch as used by
+// the zipkin wire formats.
+func microseconds(t time.Time) int64 {
+	return t.UnixNano() / 1e3
+}
+
+// durationMicroseconds converts a duration into microseconds. Since all spans
+// are timed, durations below one microsecond are rounded up.
+func durationMicroseconds(d time.Duration) int64 {
+	var result = d.Nanoseconds() / 1e3
+	if result == 0 {
+		result = 1
+	}
 	return result
 }
 
@@ -121,3 +358,35 @@ type frame struct {
 	Zipkin  jsonSpan `logevent:"zipkin"`
 	Message string   `logevent:"message,default=span-complete"`
 }
+
+type v2Endpoint struct {
+	ServiceName string `logevent:"serviceName"`
+	Ipv4        string `logevent:"ipv4"`
+	Ipv6        string `logevent:"ipv6"`
+	Port        int    `logevent:"port"`
+}
+
+type v2Annotation struct {
+	Timestamp int64                  `logevent:"timestamp" json:"timestamp"`
+	Value     string                 `logevent:"value" json:"value"`
+	Fields    map[string]interface{} `logevent:"fields" json:"fields,omitempty"`
+}
+
+type v2Span struct {
+	TraceID        string            `logevent:"traceId"`
+	SpanID         string            `logevent:"id"`
+	ParentID       string            `logevent:"parentId"`
+	Name           string            `logevent:"name"`
+	Kind           string            `logevent:"kind"`
+	Timestamp      int64             `logevent:"timestamp"`
+	Duration       int64             `logevent:"duration"`
+	LocalEndpoint  v2Endpoint        `logevent:"localEndpoint"`
+	RemoteEndpoint v2Endpoint        `logevent:"remoteEndpoint"`
+	Annotations    []v2Annotation    `logevent:"annotations"`
+	Tags           map[string]string `logevent:"tags"`
+}
+
+type v2Frame struct {
+	Zipkin  v2Span `logevent:"zipkin"`
+	Message string `logevent:"message,default=span-complete"`
+}
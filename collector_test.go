@@ -1,10 +1,22 @@
 package httptrace
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net"
+	"regexp"
 	"testing"
+	"time"
 
+	"github.com/asecurityteam/logevent"
 	"github.com/golang/mock/gomock"
-	"github.com/openzipkin/zipkin-go-opentracing/thrift/gen-go/zipkincore"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/openzipkin/zipkin-go/model"
 )
 
 const (
@@ -14,15 +26,57 @@ const (
 	name = "TEST"
 )
 
+// volatileLogFields matches the parts of a log line that change between runs.
+var volatileLogFields = regexp.MustCompile(`"(time|file)":"[^"]*",?`)
+
+// fixedIDs generates identifiers from fixed lists for deterministic output.
+type fixedIDs struct {
+	traceIDs []uint64
+	spanIDs  []uint64
+}
+
+func (f *fixedIDs) TraceID() model.TraceID {
+	var id = f.traceIDs[0]
+	f.traceIDs = f.traceIDs[1:]
+	return model.TraceID{Low: id}
+}
+
+func (f *fixedIDs) SpanID(model.TraceID) model.ID {
+	var id = f.spanIDs[0]
+	f.spanIDs = f.spanIDs[1:]
+	return model.ID(id)
+}
+
+func testSpanModel(parentID *model.ID) model.SpanModel {
+	return model.SpanModel{
+		SpanContext: model.SpanContext{
+			TraceID:  model.TraceID{Low: 1},
+			ID:       2,
+			ParentID: parentID,
+		},
+		Name:      name,
+		Kind:      model.Server,
+		Timestamp: time.Unix(1, 0),
+		Duration:  time.Millisecond,
+		LocalEndpoint: &model.Endpoint{
+			ServiceName: "TESTSERVICE",
+			IPv4:        net.IPv4(127, 0, 0, 1),
+			Port:        80,
+		},
+		Annotations: []model.Annotation{{Timestamp: time.Unix(1, 0), Value: "TESTANNOTATION"}},
+		Tags:        map[string]string{"TESTTAG": "TESTVALUE"},
+	}
+}
+
 func TestCollectorNoParent(t *testing.T) {
 	var ctrl = gomock.NewController(t)
 	defer ctrl.Finish()
 
 	var logger = NewMockLogger(ctrl)
 	logger.EXPECT().Info(gomock.Any()).Do(func(event interface{}) {
-		var evt frame
+		var evt v2Frame
 		var ok bool
-		if evt, ok = event.(frame); !ok {
+		if evt, ok = event.(v2Frame); !ok {
 			t.Error("did not log a zipkin frame")
 		}
 		if evt.Zipkin.ParentID != "" {
@@ -37,44 +91,24 @@ func TestCollectorNoParent(t *testing.T) {
 		if evt.Zipkin.Name != name {
 			t.Errorf("expected name TEST but found %s", evt.Zipkin.Name)
 		}
-		if len(evt.Zipkin.BinaryAnnotations) != 1 {
-			t.Errorf("expected 1 binary annotation but got %d", len(evt.Zipkin.BinaryAnnotations))
-		} else {
-			if evt.Zipkin.BinaryAnnotations[0].Key != "TESTTAG" || evt.Zipkin.BinaryAnnotations[0].Value != "TESTVALUE" {
-				t.Errorf("expected binary annotation of TESTTAG=TESTVALUE but got %s=%s", evt.Zipkin.BinaryAnnotations[0].Key, evt.Zipkin.BinaryAnnotations[0].Value)
-			}
+		if evt.Zipkin.Kind != "SERVER" {
+			t.Errorf("expected kind SERVER but found %s", evt.Zipkin.Kind)
 		}
-		if len(evt.Zipkin.Annotations) != 1 {
-			t.Errorf("expected 1 annotation but got %d", len(evt.Zipkin.Annotations))
-		} else {
-			if evt.Zipkin.Annotations[0].Value != "TESTANNOTATION" || evt.Zipkin.Annotations[0].Endpoint.ServiceName != "TESTSERVICE" {
-				t.Errorf("expected annotation TESTANNOTATION with endpoint TESTSERVICE but got %s:%s", evt.Zipkin.Annotations[0].Value, evt.Zipkin.Annotations[0].Endpoint.ServiceName)
-			}
+		if evt.Zipkin.Timestamp != 1000000 || evt.Zipkin.Duration != 1000 {
+			t.Errorf("expected timestamp 1000000 and duration 1000 but found %d and %d", evt.Zipkin.Timestamp, evt.Zipkin.Duration)
+		}
+		if evt.Zipkin.LocalEndpoint.ServiceName != "TESTSERVICE" || evt.Zipkin.LocalEndpoint.Ipv4 != "127.0.0.1" {
+			t.Errorf("unexpected local endpoint %v", evt.Zipkin.LocalEndpoint)
+		}
+		if len(evt.Zipkin.Tags) != 1 || evt.Zipkin.Tags["TESTTAG"] != "TESTVALUE" {
+			t.Errorf("expected tag TESTTAG=TESTVALUE but got %v", evt.Zipkin.Tags)
+		}
+		if len(evt.Zipkin.Annotations) != 1 || evt.Zipkin.Annotations[0].Value != "TESTANNOTATION" {
+			t.Errorf("expected annotation TESTANNOTATION but got %v", evt.Zipkin.Annotations)
 		}
 	})
-	var collector = collector{logger}
-	var span = &zipkincore.Span{
-		ParentID: nil,
-		TraceID:  1,
-		ID:       2,
-		Name:     name,
-		Annotations: []*zipkincore.Annotation{
-			&zipkincore.Annotation{
-				Value: "TESTANNOTATION",
-				Host: &zipkincore.Endpoint{
-					ServiceName: "TESTSERVICE",
-					Port:        80,
-				},
-			},
-		},
-		BinaryAnnotations: []*zipkincore.BinaryAnnotation{
-			&zipkincore.BinaryAnnotation{
-				Key:   "TESTTAG",
-				Value: []byte("TESTVALUE"),
-			},
-		},
-	}
-	_ = collector.Collect(span)
+	var collector = collector{Logger: logger, emit: logger.Info}
+	collector.Send(testSpanModel(nil))
 }
 
 func TestCollectorWithParent(t *testing.T) {
@@ -83,9 +117,9 @@ func TestCollectorWithParent(t *testing.T) {
 
 	var logger = NewMockLogger(ctrl)
 	logger.EXPECT().Info(gomock.Any()).Do(func(event interface{}) {
-		var evt frame
+		var evt v2Frame
 		var ok bool
-		if evt, ok = event.(frame); !ok {
+		if evt, ok = event.(v2Frame); !ok {
 			t.Error("did not log a zipkin frame")
 		}
 		if evt.Zipkin.ParentID != id3 {
@@ -97,46 +131,228 @@ func TestCollectorWithParent(t *testing.T) {
 		if evt.Zipkin.SpanID != id2 {
 			t.Errorf("expected span 0000000000000002 but found %s", evt.Zipkin.SpanID)
 		}
-		if evt.Zipkin.Name != name {
-			t.Errorf("expected name TEST but found %s", evt.Zipkin.Name)
+	})
+	var collector = collector{Logger: logger, emit: logger.Info}
+	var parentID = model.ID(3)
+	collector.Send(testSpanModel(&parentID))
+}
+
+func TestCollectorLegacyFormat(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var logger = NewMockLogger(ctrl)
+	logger.EXPECT().Info(gomock.Any()).Do(func(event interface{}) {
+		var evt frame
+		var ok bool
+		if evt, ok = event.(frame); !ok {
+			t.Error("did not log a legacy zipkin frame")
 		}
-		if len(evt.Zipkin.BinaryAnnotations) != 1 {
-			t.Errorf("expected 1 binary annotation but got %d", len(evt.Zipkin.BinaryAnnotations))
-		} else {
-			if evt.Zipkin.BinaryAnnotations[0].Key != "TESTTAG" || evt.Zipkin.BinaryAnnotations[0].Value != "TESTVALUE" {
-				t.Errorf("expected binary annotation of TESTTAG=TESTVALUE but got %s=%s", evt.Zipkin.BinaryAnnotations[0].Key, evt.Zipkin.BinaryAnnotations[0].Value)
-			}
+		if evt.Zipkin.ParentID != id3 {
+			t.Errorf("expected parent 0000000000000003 but found %s", evt.Zipkin.ParentID)
 		}
-		if len(evt.Zipkin.Annotations) != 1 {
-			t.Errorf("expected 1 annotation but got %d", len(evt.Zipkin.Annotations))
-		} else {
-			if evt.Zipkin.Annotations[0].Value != "TESTANNOTATION" || evt.Zipkin.Annotations[0].Endpoint.ServiceName != "TESTSERVICE" {
-				t.Errorf("expected annotation TESTANNOTATION with endpoint TESTSERVICE but got %s:%s", evt.Zipkin.Annotations[0].Value, evt.Zipkin.Annotations[0].Endpoint.ServiceName)
-			}
+		if len(evt.Zipkin.BinaryAnnotations) != 2 {
+			t.Fatalf("expected 2 binary annotations but got %d", len(evt.Zipkin.BinaryAnnotations))
+		}
+		if evt.Zipkin.BinaryAnnotations[0].Key != "TESTTAG" || evt.Zipkin.BinaryAnnotations[0].Value != "TESTVALUE" {
+			t.Errorf("expected binary annotation of TESTTAG=TESTVALUE but got %s=%s", evt.Zipkin.BinaryAnnotations[0].Key, evt.Zipkin.BinaryAnnotations[0].Value)
+		}
+		if evt.Zipkin.BinaryAnnotations[1].Key != "span.kind" || evt.Zipkin.BinaryAnnotations[1].Value != "server" {
+			t.Errorf("expected binary annotation of span.kind=server but got %s=%s", evt.Zipkin.BinaryAnnotations[1].Key, evt.Zipkin.BinaryAnnotations[1].Value)
+		}
+		if len(evt.Zipkin.Annotations) != 3 {
+			t.Fatalf("expected 3 annotations but got %d", len(evt.Zipkin.Annotations))
+		}
+		if evt.Zipkin.Annotations[0].Value != "sr" || evt.Zipkin.Annotations[1].Value != "ss" {
+			t.Errorf("expected sr and ss annotations but got %s and %s", evt.Zipkin.Annotations[0].Value, evt.Zipkin.Annotations[1].Value)
+		}
+		if evt.Zipkin.Annotations[2].Value != "TESTANNOTATION" || evt.Zipkin.Annotations[2].Endpoint.ServiceName != "TESTSERVICE" {
+			t.Errorf("expected annotation TESTANNOTATION with endpoint TESTSERVICE but got %s:%s", evt.Zipkin.Annotations[2].Value, evt.Zipkin.Annotations[2].Endpoint.ServiceName)
 		}
 	})
-	var collector = collector{logger}
-	var parentID = int64(3)
-	var span = &zipkincore.Span{
-		ParentID: &parentID,
-		TraceID:  1,
-		ID:       2,
-		Name:     name,
-		Annotations: []*zipkincore.Annotation{
-			&zipkincore.Annotation{
-				Value: "TESTANNOTATION",
-				Host: &zipkincore.Endpoint{
-					ServiceName: "TESTSERVICE",
-					Port:        80,
-				},
-			},
-		},
-		BinaryAnnotations: []*zipkincore.BinaryAnnotation{
-			&zipkincore.BinaryAnnotation{
-				Key:   "TESTTAG",
-				Value: []byte("TESTVALUE"),
-			},
+	var collector = collector{Logger: logger, legacy: true, emit: logger.Info}
+	var parentID = model.ID(3)
+	collector.Send(testSpanModel(&parentID))
+}
+
+// TestLegacyFormatGolden verifies that spans recorded with the legacy format
+// option produce log lines identical to those of the zipkin-go-opentracing
+// based implementation. The golden file was generated by that implementation
+// and must not be regenerated.
+func TestLegacyFormatGolden(t *testing.T) {
+	var buf bytes.Buffer
+	var logger = logevent.New(logevent.Config{Output: &buf, Level: "INFO"})
+	var tr, _ = NewTracer(logger, "testservice", "127.0.0.1:8080", TracerOptionLegacyFormat(true))
+	tr.(*tracer).idGenerator = &fixedIDs{traceIDs: []uint64{5}, spanIDs: []uint64{2, 4, 6}}
+	var start = time.Date(2019, 1, 2, 3, 4, 5, 6000, time.UTC)
+
+	var remote = spanContext{SpanContext: model.SpanContext{TraceID: model.TraceID{Low: 1}, ID: 3}}
+	var server = tr.StartSpan("testservice", opentracing.ChildOf(remote), opentracing.StartTime(start))
+	server.SetTag("component", "test")
+	var client = tr.StartSpan("OutgoingHTTPRequest", opentracing.ChildOf(server.Context()), opentracing.StartTime(start.Add(100*time.Microsecond)))
+	ext.SpanKindRPCClient.Set(client)
+	ext.HTTPMethod.Set(client, "GET")
+	ext.HTTPUrl.Set(client, "/path")
+	ext.PeerService.Set(client, "dependency")
+	ext.HTTPStatusCode.Set(client, uint16(500))
+	ext.Error.Set(client, true)
+	server.FinishWithOptions(opentracing.FinishOptions{FinishTime: start.Add(1500 * time.Microsecond)})
+	client.FinishWithOptions(opentracing.FinishOptions{FinishTime: start.Add(1100 * time.Microsecond)})
+
+	var root = tr.StartSpan("testservice", opentracing.StartTime(start))
+	root.FinishWithOptions(opentracing.FinishOptions{
+		FinishTime: start,
+		LogRecords: []opentracing.LogRecord{
+			{Timestamp: start.Add(time.Microsecond), Fields: []log.Field{log.String("event", "cache-miss")}},
+			{Timestamp: start.Add(2 * time.Microsecond), Fields: []log.Field{log.String("key", "some value"), log.Int("count", 3)}},
 		},
+	})
+
+	var expected, err = ioutil.ReadFile("testdata/legacy_format.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual = volatileLogFields.ReplaceAll(buf.Bytes(), nil)
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("legacy output does not match golden file\nexpected:\n%s\nactual:\n%s", expected, actual)
+	}
+}
+
+func TestCollectorStructuredLogs(t *testing.T) {
+	var tests = []struct {
+		name    string
+		options []TracerOption
+	}{
+		{"direct", nil},
+		{"tail sampled", []TracerOption{TracerOptionTailSampling(func([]model.SpanModel) bool { return true })}},
+		{"multiple reporters", []TracerOption{TracerOptionAddReporter(NewRecorder())}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var logger = logevent.New(logevent.Config{Output: &buf, Level: "INFO"})
+			var tr, _ = NewTracer(logger, "testservice", "127.0.0.1:8080", tt.options...)
+			var span = tr.StartSpan("test")
+			span.LogFields(log.String("event", "cache-miss"))
+			span.LogFields(log.String("key", "some value"), log.Int("count", 3), log.Bool("hit", false))
+			span.LogFields(log.String("event", "status=ok"))
+			span.LogEvent("user bob=1 failed")
+			span.Finish()
+
+			var line struct {
+				Zipkin struct {
+					Annotations []struct {
+						Value  string                 `json:"value"`
+						Fields map[string]interface{} `json:"fields"`
+					} `json:"annotations"`
+				} `json:"zipkin"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatal(err)
+			}
+			var annotations = line.Zipkin.Annotations
+			if len(annotations) != 4 {
+				t.Fatalf("expected 4 annotations but got %d", len(annotations))
+			}
+			for _, offset := range []int{0, 2, 3} {
+				if annotations[offset].Fields != nil {
+					t.Errorf("expected plain event %q to have no fields but got %v", annotations[offset].Value, annotations[offset].Fields)
+				}
+			}
+			if annotations[2].Value != "status=ok" || annotations[3].Value != "user bob=1 failed" {
+				t.Errorf("unexpected event values %q and %q", annotations[2].Value, annotations[3].Value)
+			}
+			var fields = annotations[1].Fields
+			if fields["key"] != "some value" || fields["count"] != float64(3) || fields["hit"] != false {
+				t.Errorf("expected fields key=some value, count=3, and hit=false but got %v", fields)
+			}
+		})
+	}
+}
+
+func TestLogFieldsTypes(t *testing.T) {
+	var fields = logFields([]log.Field{
+		log.Int64("int", 1),
+		log.Float64("nan", math.NaN()),
+		log.Error(errors.New("failure")),
+		log.Lazy(func(e log.Encoder) { e.EmitUint32("lazy", 2) }),
+	})
+	if fields["int"] != int64(1) || fields["nan"] != "NaN" || fields["error.object"] != "failure" || fields["lazy"] != uint32(2) {
+		t.Errorf("unexpected fields %v", fields)
+	}
+}
+
+func TestCollectorLogOptions(t *testing.T) {
+	var buf bytes.Buffer
+	var logger = logevent.New(logevent.Config{Output: &buf, Level: "DEBUG"})
+	var tr, err = NewTracer(logger, "testservice", "127.0.0.1:8080",
+		TracerOptionLogMessage("trace-span"),
+		TracerOptionLogSpanKey("span"),
+		TracerOptionLogLevel("debug"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.StartSpan("test").Finish()
+
+	var line map[string]interface{}
+	if err = json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["message"] != "trace-span" {
+		t.Errorf("expected message trace-span but got %v", line["message"])
+	}
+	if line["level"] != "debug" {
+		t.Errorf("expected level debug but got %v", line["level"])
+	}
+	if _, ok := line["zipkin"]; ok {
+		t.Error("span was logged under the default key")
+	}
+	var span, _ = line["span"].(map[string]interface{})
+	if span["name"] != "test" {
+		t.Errorf("expected span named test under the span key but got %v", line["span"])
+	}
+}
+
+func TestCollectorLogFlatten(t *testing.T) {
+	var buf bytes.Buffer
+	var logger = logevent.New(logevent.Config{Output: &buf, Level: "INFO"})
+	var tr, _ = NewTracer(logger, "testservice", "127.0.0.1:8080", TracerOptionLogFlatten(true))
+	tr.StartSpan("test").Finish()
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["message"] != "span-complete" {
+		t.Errorf("expected message span-complete but got %v", line["message"])
+	}
+	if line["name"] != "test" || line["traceId"] == nil {
+		t.Errorf("expected span fields at the top level but got %v", line)
+	}
+	if _, ok := line["zipkin"]; ok {
+		t.Error("span was logged under the default key")
+	}
+}
+
+func TestCollectorUnknownLevel(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var _, err = NewTracer(NewMockLogger(ctrl), "testservice", "127.0.0.1:8080", TracerOptionLogLevel("loud"))
+	if err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestCollectorNilLogger(t *testing.T) {
+	if _, err := NewTracer(nil, "testservice", "127.0.0.1:8080"); err == nil {
+		t.Error("expected an error for a nil logger")
+	}
+	if _, err := SetupGlobalTracer(nil, "testservice", "127.0.0.1:8080"); err == nil {
+		t.Error("expected an error for a nil logger")
+	}
+	if _, err := NewTracer(nil, "testservice", "127.0.0.1:8080", TracerOptionReporter(NewRecorder())); err != nil {
+		t.Errorf("expected no logger to be needed with a custom reporter but got %v", err)
 	}
-	_ = collector.Collect(span)
 }
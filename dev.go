@@ -0,0 +1,116 @@
+package httptrace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+// devCollector implements the zipkin-go Reporter interface by buffering the
+// spans of each trace and writing them as an indented tree once the trace is
+// complete.
+type devCollector struct {
+	w      io.Writer
+	lock   sync.Mutex
+	traces map[model.TraceID][]model.SpanModel
+}
+
+func newDevCollector(w io.Writer) *devCollector {
+	return &devCollector{w: w, traces: make(map[model.TraceID][]model.SpanModel)}
+}
+
+func (c *devCollector) Send(s model.SpanModel) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.traces[s.TraceID] = append(c.traces[s.TraceID], s)
+}
+
+// FinishTrace writes the tree of buffered spans for the trace.
+func (c *devCollector) FinishTrace(id model.TraceID) {
+	c.lock.Lock()
+	var spans = c.traces[id]
+	delete(c.traces, id)
+	c.lock.Unlock()
+	if len(spans) < 1 {
+		return
+	}
+	var buf = bytes.NewBuffer(nil)
+	writeSpanTree(buf, id, spans)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, _ = c.w.Write(buf.Bytes())
+}
+
+// Close writes the tree of buffered spans for each incomplete trace.
+func (c *devCollector) Close() error {
+	c.lock.Lock()
+	var ids = make([]model.TraceID, 0, len(c.traces))
+	for id := range c.traces {
+		ids = append(ids, id)
+	}
+	c.lock.Unlock()
+	for _, id := range ids {
+		c.FinishTrace(id)
+	}
+	return nil
+}
+
+// writeSpanTree writes the spans of a trace with each child indented beneath
+// its parent. Spans whose parent was not recorded, such as the local root of a
+// trace that started in another service, are written at the top level.
+// Siblings are ordered by their start time.
+func writeSpanTree(w io.Writer, id model.TraceID, spans []model.SpanModel) {
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].Timestamp.Before(spans[j].Timestamp)
+	})
+	var known = make(map[model.ID]bool, len(spans))
+	for _, s := range spans {
+		known[s.ID] = true
+	}
+	var roots []model.SpanModel
+	var children = make(map[model.ID][]model.SpanModel)
+	for _, s := range spans {
+		if s.ParentID == nil || !known[*s.ParentID] {
+			roots = append(roots, s)
+			continue
+		}
+		children[*s.ParentID] = append(children[*s.ParentID], s)
+	}
+	fmt.Fprintf(w, "trace %s\n", id)
+	var write func(s model.SpanModel, depth int)
+	write = func(s model.SpanModel, depth int) {
+		fmt.Fprintf(w, "%s%s\n", strings.Repeat("  ", depth+1), spanSummary(s))
+		for _, child := range children[s.ID] {
+			write(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		write(root, 0)
+	}
+}
+
+// spanSummary renders a single line describing the span.
+func spanSummary(s model.SpanModel) string {
+	var parts = []string{s.Name}
+	if s.Kind != "" {
+		parts = append(parts, strings.ToLower(string(s.Kind)))
+	}
+	if s.RemoteEndpoint != nil && s.RemoteEndpoint.ServiceName != "" {
+		parts = append(parts, "-> "+s.RemoteEndpoint.ServiceName)
+	}
+	parts = append(parts, s.Duration.Round(time.Microsecond).String())
+	if status, ok := s.Tags[string(ext.HTTPStatusCode)]; ok {
+		parts = append(parts, "status="+status)
+	}
+	if s.Tags[string(ext.Error)] == "true" {
+		parts = append(parts, "error")
+	}
+	return strings.Join(parts, " ")
+}
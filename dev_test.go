@@ -0,0 +1,98 @@
+package httptrace
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+func TestDevelopmentTree(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var buf bytes.Buffer
+	var tr, _ = NewTracer(NewMockLogger(ctrl), "testservice", "127.0.0.1:8080", TracerOptionDevelopment(&buf))
+	tr.(*tracer).idGenerator = &fixedIDs{traceIDs: []uint64{1}, spanIDs: []uint64{1, 2, 3}}
+	var start = time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var root = tr.StartSpan("testservice", opentracing.StartTime(start))
+	var first = tr.StartSpan("OutgoingHTTPRequest", opentracing.ChildOf(root.Context()), opentracing.StartTime(start.Add(time.Millisecond)))
+	ext.SpanKindRPCClient.Set(first)
+	ext.PeerService.Set(first, "dependency")
+	ext.HTTPStatusCode.Set(first, uint16(500))
+	ext.Error.Set(first, true)
+	var second = tr.StartSpan("cache", opentracing.ChildOf(root.Context()), opentracing.StartTime(start.Add(2*time.Millisecond)))
+	second.FinishWithOptions(opentracing.FinishOptions{FinishTime: start.Add(2500 * time.Microsecond)})
+	root.FinishWithOptions(opentracing.FinishOptions{FinishTime: start.Add(3 * time.Millisecond)})
+	if buf.Len() > 0 {
+		t.Fatalf("tree was written before the trace was complete:\n%s", buf.String())
+	}
+	first.FinishWithOptions(opentracing.FinishOptions{FinishTime: start.Add(4 * time.Millisecond)})
+
+	var expected = "trace 0000000000000001\n" +
+		"  testservice 3ms\n" +
+		"    OutgoingHTTPRequest client -> dependency 3ms status=500 error\n" +
+		"    cache 500µs\n"
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, buf.String())
+	}
+}
+
+func TestDevelopmentTreeUnfinishedSpan(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var buf bytes.Buffer
+	var tr, _ = NewTracer(NewMockLogger(ctrl), "testservice", "127.0.0.1:8080",
+		TracerOptionDevelopment(&buf),
+		TracerOptionMaxOpenTraces(1),
+	)
+	tr.(*tracer).idGenerator = &fixedIDs{traceIDs: []uint64{1, 2}, spanIDs: []uint64{1, 2, 3}}
+	var start = time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var root = tr.StartSpan("testservice", opentracing.StartTime(start))
+	var child = tr.StartSpan("cache", opentracing.ChildOf(root.Context()), opentracing.StartTime(start))
+	child.FinishWithOptions(opentracing.FinishOptions{FinishTime: start.Add(time.Millisecond)})
+	if buf.Len() > 0 {
+		t.Fatalf("tree was written before the trace was complete:\n%s", buf.String())
+	}
+	tr.StartSpan("testservice", opentracing.StartTime(start))
+
+	var expected = "trace 0000000000000001\n" +
+		"  cache 1ms\n"
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, buf.String())
+	}
+}
+
+func TestDevelopmentTreeClose(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var buf bytes.Buffer
+	var teardown, _ = SetupGlobalTracer(NewMockLogger(ctrl), "testservice", "127.0.0.1:8080", TracerOptionDevelopment(&buf))
+	var tr = opentracing.GlobalTracer()
+	tr.(*tracer).idGenerator = &fixedIDs{traceIDs: []uint64{1}, spanIDs: []uint64{1, 2}}
+	var start = time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// The root of the trace is never finished.
+	var root = tr.StartSpan("testservice", opentracing.StartTime(start))
+	var child = tr.StartSpan("cache", opentracing.ChildOf(root.Context()), opentracing.StartTime(start))
+	child.FinishWithOptions(opentracing.FinishOptions{FinishTime: start.Add(time.Millisecond)})
+	if buf.Len() > 0 {
+		t.Fatalf("tree was written before the trace was complete:\n%s", buf.String())
+	}
+	if err := teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	var expected = "trace 0000000000000001\n" +
+		"  cache 1ms\n"
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, buf.String())
+	}
+}
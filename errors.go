@@ -0,0 +1,47 @@
+package httptrace
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/asecurityteam/logevent"
+)
+
+// ErrorHandler receives errors raised by the tracing infrastructure, such as
+// failing to construct a tracer or to inject trace headers. These errors never
+// interrupt the request being traced.
+type ErrorHandler func(context.Context, error)
+
+type tracingError struct {
+	Reason  string `logevent:"reason"`
+	Message string `logevent:"message,default=tracing-error"`
+}
+
+// LogErrorOnce returns an ErrorHandler that emits only the first error it
+// receives using the logevent.Logger contained within the context. Subsequent
+// errors are discarded to avoid flooding the logs with identical failures on
+// every request. Errors received with a context that contains no Logger, such
+// as those of a reporter given to NewMultiReporter, are also discarded. It is
+// the default handler of the Middleware and Transport.
+func LogErrorOnce() ErrorHandler {
+	var logged uint32
+	return func(ctx context.Context, err error) {
+		var logger = loggerFromContext(ctx)
+		if logger == nil || !atomic.CompareAndSwapUint32(&logged, 0, 1) {
+			return
+		}
+		logger.Error(tracingError{Reason: err.Error()})
+	}
+}
+
+// loggerFromContext returns the logevent.Logger contained within the context
+// or nil if there is none. Unlike logevent.FromContext, it does not panic when
+// the context has no Logger.
+func loggerFromContext(ctx context.Context) (logger logevent.Logger) {
+	defer func() {
+		if recover() != nil {
+			logger = nil
+		}
+	}()
+	return logevent.FromContext(ctx)
+}
@@ -0,0 +1,31 @@
+package httptrace
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/golang/mock/gomock"
+)
+
+func TestLogErrorOnce(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var logger = NewMockLogger(ctrl)
+	logger.EXPECT().Error(gomock.Any()).Do(func(event interface{}) {
+		var evt, ok = event.(tracingError)
+		if !ok {
+			t.Fatal("did not log a tracing error")
+		}
+		if evt.Reason != "first" {
+			t.Errorf("expected reason first but found %s", evt.Reason)
+		}
+	})
+	var ctx = logevent.NewContext(context.Background(), logger)
+	var handler = LogErrorOnce()
+	handler(context.Background(), errors.New("no logger"))
+	handler(ctx, errors.New("first"))
+	handler(ctx, errors.New("second"))
+}
@@ -0,0 +1,55 @@
+package httptrace
+
+import (
+	"sync"
+
+	"github.com/asecurityteam/logevent"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+var (
+	globalLock   sync.RWMutex
+	globalTracer *tracer
+)
+
+// SetupGlobalTracer builds a process wide tracer that emits spans using the
+// given Logger and registers it with opentracing.SetGlobalTracer so that
+// libraries calling opentracing.GlobalTracer() record spans as well. While
+// registered, the tracer is used by every Middleware that is not given tracer
+// options, in place of a tracer built for each request, and by every Transport
+// to trace requests whose context contains no span. The returned function
+// flushes the spans of incomplete traces, closes the tracer, and restores the
+// no-op global tracer. It should be called once the process has finished
+// serving requests.
+func SetupGlobalTracer(logger logevent.Logger, serviceName string, hostPort string, options ...TracerOption) (func() error, error) {
+	var t, err = NewTracer(logger, serviceName, hostPort, options...)
+	if err != nil {
+		return nil, err
+	}
+	var zt = t.(*tracer)
+	globalLock.Lock()
+	globalTracer = zt
+	opentracing.SetGlobalTracer(zt)
+	globalLock.Unlock()
+	return func() error {
+		globalLock.Lock()
+		if globalTracer == zt {
+			globalTracer = nil
+			opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+		}
+		globalLock.Unlock()
+		return zt.reporter.Close()
+	}, nil
+}
+
+// GlobalTracer returns the tracer registered with SetupGlobalTracer or nil if
+// there is none. Unlike opentracing.GlobalTracer, it never returns a tracer
+// registered by other means.
+func GlobalTracer() opentracing.Tracer {
+	globalLock.RLock()
+	defer globalLock.RUnlock()
+	if globalTracer == nil {
+		return nil
+	}
+	return globalTracer
+}
@@ -0,0 +1,104 @@
+package httptrace
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+func TestSetupGlobalTracer(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var logger = NewMockLogger(ctrl)
+	var teardown, err = SetupGlobalTracer(logger, "testservice", "127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := opentracing.GlobalTracer().(*tracer); !ok {
+		t.Error("tracer was not registered as the global tracer")
+	}
+
+	// The request context contains no logger so spans must be emitted through
+	// the global tracer.
+	logger.EXPECT().Info(gomock.Any()).Do(func(event interface{}) {
+		var evt = event.(v2Frame)
+		if evt.Zipkin.Name != "testservice" {
+			t.Errorf("unexpected span %s", evt.Zipkin.Name)
+		}
+	})
+	var w = httptest.NewRecorder()
+	var r, _ = http.NewRequest("GET", "/", nil)
+	var wrapped = fixtureHandler{}
+	NewMiddleware(MiddlewareOptionServiceName("testservice"))(&wrapped).ServeHTTP(w, r)
+	if !wrapped.called {
+		t.Error("middleware did not call the wrapped handler")
+	}
+
+	// Requests without a span are traced as new roots.
+	logger.EXPECT().Info(gomock.Any()).Do(func(event interface{}) {
+		var evt = event.(v2Frame)
+		if evt.Zipkin.Kind != "CLIENT" || evt.Zipkin.ParentID != "" {
+			t.Errorf("expected a root client span but got %s with parent %s", evt.Zipkin.Kind, evt.Zipkin.ParentID)
+		}
+	})
+	var rt = &fixtureTransport{Response: &http.Response{StatusCode: http.StatusOK}}
+	r, _ = http.NewRequest("GET", "/", nil)
+	_, _ = NewTransport()(rt).RoundTrip(r)
+	if rt.Request.Header.Get(b3TraceIDHeader) == "" {
+		t.Error("trace headers were not injected")
+	}
+
+	if err = teardown(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := opentracing.GlobalTracer().(opentracing.NoopTracer); !ok {
+		t.Error("global tracer was not reset")
+	}
+	if GlobalTracer() != nil {
+		t.Error("global tracer is still used by default")
+	}
+}
+
+func TestGlobalTracerTracerOptionsPrecedence(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// The logger of the global tracer expects no events.
+	var teardown, err = SetupGlobalTracer(NewMockLogger(ctrl), "globalservice", "127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = teardown() }()
+
+	var recorder = NewRecorder()
+	var w = httptest.NewRecorder()
+	var r, _ = http.NewRequest("GET", "/", nil)
+	NewMiddleware(
+		MiddlewareOptionServiceName("testservice"),
+		MiddlewareOptionHostPort("127.0.0.2:8080"),
+		MiddlewareOptionTracerOptions(TracerOptionReporter(recorder)),
+	)(&fixtureHandler{}).ServeHTTP(w, r)
+	var spans = recorder.SpansNamed("testservice")
+	if len(spans) != 1 {
+		t.Fatalf("expected the middleware span to be recorded but got %d spans", len(recorder.Spans()))
+	}
+	if spans[0].LocalEndpoint == nil || spans[0].LocalEndpoint.ServiceName != "testservice" || spans[0].LocalEndpoint.IPv4.String() != "127.0.0.2" {
+		t.Errorf("expected the endpoint of the middleware but got %v", spans[0].LocalEndpoint)
+	}
+
+	recorder.Reset()
+	var _, span = NewConsumer(ConsumerOptionTracerOptions(TracerOptionReporter(recorder))).StartSpan(context.Background(), "consume", map[string]string{})
+	span.Finish()
+	if len(recorder.SpansNamed("consume")) != 1 {
+		t.Error("expected the consumer span to be recorded")
+	}
+
+	if tracer, _ := NewContextTracer(context.Background(), "testservice", "127.0.0.1:8080"); tracer != GlobalTracer() {
+		t.Error("expected the global tracer without tracer options")
+	}
+}
@@ -1,23 +1,19 @@
 module github.com/asecurityteam/httptrace
 
-go 1.12
+go 1.21
 
 require (
-	github.com/DataDog/zstd v1.4.1 // indirect
-	github.com/Shopify/sarama v1.23.1 // indirect
-	github.com/apache/thrift v0.0.0-20171203172758-327ebb6c2b6d // indirect
 	github.com/asecurityteam/logevent v1.4.0
-	github.com/eapache/go-resiliency v1.2.0 // indirect
-	github.com/go-logfmt/logfmt v0.4.0 // indirect
-	github.com/gogo/protobuf v1.2.1 // indirect
+	github.com/go-logfmt/logfmt v0.6.1
 	github.com/golang/mock v1.4.4
-	github.com/jcmturner/gofork v1.0.0 // indirect
-	github.com/opentracing-contrib/go-observer v0.0.0-20170622124052-a52f23424492 // indirect
-	github.com/opentracing/opentracing-go v1.1.0
-	github.com/openzipkin/zipkin-go-opentracing v0.3.2
-	github.com/pierrec/lz4 v2.2.6+incompatible // indirect
-	github.com/rcrowley/go-metrics v0.0.0-20190706150252-9beb055b7962 // indirect
-	golang.org/x/crypto v0.0.0-20190701094942-4def268fd1a4 // indirect
-	golang.org/x/net v0.0.0-20190813141303-74dc4d7220e7 // indirect
-	gopkg.in/jcmturner/gokrb5.v7 v7.3.0 // indirect
+	github.com/opentracing/opentracing-go v1.2.0
+	github.com/openzipkin/zipkin-go v0.4.3
+)
+
+require (
+	github.com/fatih/structs v1.1.0 // indirect
+	github.com/rs/zerolog v1.15.0 // indirect
+	github.com/stretchr/testify v1.12.1 // indirect
+	golang.org/x/sys v0.19.0 // indirect
+	google.golang.org/grpc v1.63.2 // indirect
 )
@@ -0,0 +1,163 @@
+package grpctrace
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/asecurityteam/httptrace"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Client traces outgoing gRPC calls in the same way that the httptrace
+// Transport traces outgoing HTTP requests.
+type Client struct {
+	spanName     string
+	peerName     string
+	errorHandler httptrace.ErrorHandler
+}
+
+// ClientOption is a configuration setting for the gRPC client interceptors.
+type ClientOption func(*Client) *Client
+
+// ClientOptionSpanName sets the name of the span for outgoing calls. The
+// default value for this is OutgoingGRPCRequest.
+func ClientOptionSpanName(name string) ClientOption {
+	return func(c *Client) *Client {
+		c.spanName = name
+		return c
+	}
+}
+
+// ClientOptionPeerName sets the name of the remote peer being called. The
+// default value for peers is dependency.
+func ClientOptionPeerName(name string) ClientOption {
+	return func(c *Client) *Client {
+		c.peerName = name
+		return c
+	}
+}
+
+// ClientOptionErrorHandler sets the function that receives errors from the
+// tracing infrastructure, such as a failure to inject trace metadata. The
+// default handler logs the first error it receives using the logevent.Logger
+// contained within the call context.
+func ClientOptionErrorHandler(handler httptrace.ErrorHandler) ClientOption {
+	return func(c *Client) *Client {
+		c.errorHandler = handler
+		return c
+	}
+}
+
+// NewClient creates the client side tracing configuration.
+func NewClient(options ...ClientOption) *Client {
+	var client = &Client{
+		spanName:     "OutgoingGRPCRequest",
+		peerName:     "dependency",
+		errorHandler: httptrace.LogErrorOnce(),
+	}
+	for _, option := range options {
+		client = option(client)
+	}
+	return client
+}
+
+// startSpan starts the client span for a call and returns a context whose
+// outgoing metadata carries the span. Calls are traced when the context
+// contains a span, as within the Middleware or a server interceptor, or when
+// a tracer is registered with httptrace.SetupGlobalTracer. A nil span is
+// returned otherwise.
+func (c *Client) startSpan(ctx context.Context, method string) (context.Context, opentracing.Span) {
+	var span opentracing.Span
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		span = parent.Tracer().StartSpan(c.spanName, opentracing.ChildOf(parent.Context()))
+	} else if tracer := httptrace.GlobalTracer(); tracer != nil {
+		span = tracer.StartSpan(c.spanName)
+	} else {
+		return ctx, nil
+	}
+	ext.SpanKindRPCClient.Set(span)
+	ext.PeerService.Set(span, c.peerName)
+	span.SetTag(methodTag, method)
+	var md, ok = metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	if err := span.Tracer().Inject(span.Context(), opentracing.TextMap, metadataCarrier(md)); err != nil {
+		c.errorHandler(ctx, err)
+	}
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+// UnaryInterceptor returns an interceptor that traces unary calls.
+func (c *Client) UnaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		var spanCtx, span = c.startSpan(ctx, method)
+		if span == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		var err = invoker(spanCtx, method, req, reply, cc, opts...)
+		finishSpan(span, err)
+		return err
+	}
+}
+
+// StreamInterceptor returns an interceptor that traces streaming calls. The
+// span finishes when the stream ends with an error, when the server closes a
+// server stream, or when the single response of a client stream is received.
+// Streams that are abandoned without reaching any of these points are not
+// recorded.
+func (c *Client) StreamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		var spanCtx, span = c.startSpan(ctx, method)
+		if span == nil {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		var cs, err = streamer(spanCtx, desc, cc, method, opts...)
+		if err != nil {
+			finishSpan(span, err)
+			return cs, err
+		}
+		return &clientStream{ClientStream: cs, desc: desc, span: span}, nil
+	}
+}
+
+// clientStream finishes the span of a stream once the stream is complete.
+type clientStream struct {
+	grpc.ClientStream
+	desc *grpc.StreamDesc
+	span opentracing.Span
+	once sync.Once
+}
+
+func (s *clientStream) finish(err error) {
+	s.once.Do(func() {
+		finishSpan(s.span, err)
+	})
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	var err = s.ClientStream.SendMsg(m)
+	if err != nil && err != io.EOF {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	var err = s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	case !s.desc.ServerStreams:
+		s.finish(nil)
+	}
+	return err
+}
@@ -0,0 +1,23 @@
+module github.com/asecurityteam/httptrace/grpctrace
+
+go 1.22.0
+
+require (
+	github.com/asecurityteam/httptrace v0.0.0-20261019062502-b6209d64aaf5
+	github.com/asecurityteam/logevent v1.4.0
+	github.com/opentracing/opentracing-go v1.2.0
+	google.golang.org/grpc v1.71.1
+)
+
+require (
+	github.com/fatih/structs v1.1.0 // indirect
+	github.com/go-logfmt/logfmt v0.6.1 // indirect
+	github.com/openzipkin/zipkin-go v0.4.3 // indirect
+	github.com/rs/zerolog v1.15.0 // indirect
+	go.yaml.in/yaml/v3 v3.0.5 // indirect
+	golang.org/x/net v0.34.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250115164207-1a7da9e5054f // indirect
+	google.golang.org/protobuf v1.36.4 // indirect
+)
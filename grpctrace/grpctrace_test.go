@@ -0,0 +1,165 @@
+package grpctrace
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/asecurityteam/httptrace"
+	"github.com/asecurityteam/logevent"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fixtureHealth struct {
+	grpc_health_v1.UnimplementedHealthServer
+	traceIDs []string
+}
+
+func (h *fixtureHealth) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	h.traceIDs = append(h.traceIDs, httptrace.TraceIDFromContext(ctx))
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func (h *fixtureHealth) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	h.traceIDs = append(h.traceIDs, httptrace.TraceIDFromContext(stream.Context()))
+	return stream.Send(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING})
+}
+
+func dial(t *testing.T, recorder *httptrace.Recorder, health *fixtureHealth) *grpc.ClientConn {
+	return dialServer(t, health,
+		ServerOptionServiceName("testservice"),
+		ServerOptionTracerOptions(httptrace.TracerOptionReporter(recorder)),
+	)
+}
+
+func dialServer(t *testing.T, health *fixtureHealth, options ...ServerOption) *grpc.ClientConn {
+	var listener = bufconn.Listen(1024 * 1024)
+	var server = NewServer(options...)
+	var s = grpc.NewServer(
+		grpc.UnaryInterceptor(server.UnaryInterceptor()),
+		grpc.StreamInterceptor(server.StreamInterceptor()),
+	)
+	grpc_health_v1.RegisterHealthServer(s, health)
+	go func() { _ = s.Serve(listener) }()
+	t.Cleanup(s.Stop)
+
+	var client = NewClient(ClientOptionPeerName("testpeer"))
+	var conn, err = grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(client.UnaryInterceptor()),
+		grpc.WithStreamInterceptor(client.StreamInterceptor()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestInterceptors(t *testing.T) {
+	var recorder = httptrace.NewRecorder()
+	var health = &fixtureHealth{}
+	var conn = dial(t, recorder, health)
+	var client = grpc_health_v1.NewHealthClient(conn)
+
+	var tracer, _ = httptrace.NewTracer(nil, "caller", "127.0.0.1:8080", httptrace.TracerOptionReporter(recorder))
+	var root = tracer.StartSpan("caller")
+	var ctx = httptrace.ContextWithSpan(context.Background(), root)
+	var traceID = httptrace.TraceIDFromContext(ctx)
+
+	if _, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	var stream, err = client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+	root.Finish()
+
+	for _, id := range health.traceIDs {
+		if id != traceID {
+			t.Errorf("expected handler trace %s but found %s", traceID, id)
+		}
+	}
+	var servers = recorder.SpansNamed("testservice")
+	var clients = recorder.SpansNamed("OutgoingGRPCRequest")
+	if len(servers) != 2 || len(clients) != 2 {
+		t.Fatalf("expected 2 server and 2 client spans but got %d and %d", len(servers), len(clients))
+	}
+	for offset := range clients {
+		recorder.AssertChildOf(t, clients[offset], recorder.SpansNamed("caller")[0])
+		recorder.AssertTag(t, clients[offset], "peer.service", "testpeer")
+		recorder.AssertTag(t, clients[offset], "grpc.status_code", "OK")
+		recorder.AssertTag(t, servers[offset], "span.kind", "server")
+	}
+	var methods = make(map[string]bool)
+	for _, s := range servers {
+		var method, _ = s.Tags[methodTag]
+		methods[method] = true
+		var parent bool
+		for _, c := range clients {
+			parent = parent || (s.ParentID != nil && *s.ParentID == c.ID)
+		}
+		if !parent {
+			t.Errorf("server span for %s is not a child of a client span", method)
+		}
+	}
+	if !methods["/grpc.health.v1.Health/Check"] || !methods["/grpc.health.v1.Health/Watch"] {
+		t.Errorf("expected spans for Check and Watch but got %v", methods)
+	}
+}
+
+func TestClientNoopIfNoParent(t *testing.T) {
+	var recorder = httptrace.NewRecorder()
+	var health = &fixtureHealth{}
+	var conn = dial(t, recorder, health)
+	if _, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.SpansNamed("OutgoingGRPCRequest")) != 0 {
+		t.Error("traced a call without a parent span")
+	}
+	var servers = recorder.SpansNamed("testservice")
+	if len(servers) != 1 || servers[0].ParentID != nil {
+		t.Error("expected a single root server span")
+	}
+}
+
+func TestServerDefaultOptions(t *testing.T) {
+	var health = &fixtureHealth{}
+	var conn = dialServer(t, health, ServerOptionServiceName("testservice"))
+	if _, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(health.traceIDs) != 1 {
+		t.Errorf("expected the handler to be called once but got %d calls", len(health.traceIDs))
+	}
+}
+
+func TestServerOptionLogger(t *testing.T) {
+	var output bytes.Buffer
+	var health = &fixtureHealth{}
+	var conn = dialServer(t, health,
+		ServerOptionServiceName("testservice"),
+		ServerOptionLogger(logevent.New(logevent.Config{Output: &output})),
+	)
+	if _, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), `"name":"testservice"`) {
+		t.Errorf("expected the server span to be logged but got %s", output.String())
+	}
+}
@@ -0,0 +1,32 @@
+// Package grpctrace provides gRPC interceptors that trace calls with the same
+// tracer, span log format, and B3 propagation as the httptrace Middleware and
+// Transport so that traces cross between HTTP and gRPC services. It is a
+// separate module so that users of httptrace do not depend on gRPC.
+package grpctrace
+
+import (
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// metadataCarrier satisfies both TextMapWriter and TextMapReader for gRPC
+// metadata. Metadata keys are always lower case.
+type metadataCarrier metadata.MD
+
+// Set conforms to the TextMapWriter interface.
+func (c metadataCarrier) Set(key, val string) {
+	metadata.MD(c).Set(strings.ToLower(key), val)
+}
+
+// ForeachKey conforms to the TextMapReader interface.
+func (c metadataCarrier) ForeachKey(handler func(key, val string) error) error {
+	for k, vals := range c {
+		for _, v := range vals {
+			if err := handler(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
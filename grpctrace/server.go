@@ -0,0 +1,189 @@
+package grpctrace
+
+import (
+	"context"
+
+	"github.com/asecurityteam/httptrace"
+	"github.com/asecurityteam/logevent"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	methodTag       = "grpc.method"
+	statusCodeTag   = "grpc.status_code"
+	extractErrorTag = "extract.error"
+)
+
+// Server traces incoming gRPC calls in the same way that the httptrace
+// Middleware traces incoming HTTP requests.
+type Server struct {
+	serviceName   string
+	hostPort      string
+	logger        logevent.Logger
+	tracerOptions []httptrace.TracerOption
+	errorHandler  httptrace.ErrorHandler
+	newTracer     func(context.Context) (opentracing.Tracer, error)
+}
+
+// ServerOption is a configuration setting for the gRPC server interceptors.
+type ServerOption func(*Server) *Server
+
+// ServerOptionServiceName sets the service name annotation of the spans
+// associated with incoming calls. The default value of this option is
+// GRPCService.
+func ServerOptionServiceName(name string) ServerOption {
+	return func(s *Server) *Server {
+		s.serviceName = name
+		return s
+	}
+}
+
+// ServerOptionHostPort sets host:port annotation used to represent the
+// service in spans associated with incoming calls. The default value of this
+// option is 0.0.0.0:80.
+func ServerOptionHostPort(hostPort string) ServerOption {
+	return func(s *Server) *Server {
+		s.hostPort = hostPort
+		return s
+	}
+}
+
+// ServerOptionLogger sets the Logger used to emit spans and tracing errors.
+// The contexts of gRPC calls contain no Logger unless another interceptor adds
+// one so, without this option, calls are not traced unless the tracer options
+// select another destination for spans or a tracer is registered with
+// httptrace.SetupGlobalTracer. The Logger is also added to the context of each
+// call.
+func ServerOptionLogger(logger logevent.Logger) ServerOption {
+	return func(s *Server) *Server {
+		s.logger = logger
+		return s
+	}
+}
+
+// ServerOptionTracerOptions sets the options used when constructing the tracer
+// for each call. As with the httptrace Middleware, setting any options means
+// the tracer registered with httptrace.SetupGlobalTracer is not used.
+func ServerOptionTracerOptions(options ...httptrace.TracerOption) ServerOption {
+	return func(s *Server) *Server {
+		s.tracerOptions = options
+		return s
+	}
+}
+
+// ServerOptionTracer sets the tracer used for every call in place of the
+// default zipkin tracer.
+func ServerOptionTracer(tracer opentracing.Tracer) ServerOption {
+	return func(s *Server) *Server {
+		s.newTracer = func(context.Context) (opentracing.Tracer, error) {
+			return tracer, nil
+		}
+		return s
+	}
+}
+
+// ServerOptionErrorHandler sets the function that receives errors from the
+// tracing infrastructure. The default handler logs the first error it
+// receives using the logevent.Logger contained within the call context, if
+// there is one.
+func ServerOptionErrorHandler(handler httptrace.ErrorHandler) ServerOption {
+	return func(s *Server) *Server {
+		s.errorHandler = handler
+		return s
+	}
+}
+
+// NewServer creates the server side tracing configuration.
+func NewServer(options ...ServerOption) *Server {
+	var server = &Server{
+		serviceName:  "GRPCService",
+		hostPort:     "0.0.0.0:80",
+		errorHandler: httptrace.LogErrorOnce(),
+	}
+	server.newTracer = server.newContextTracer
+	for _, option := range options {
+		server = option(server)
+	}
+	return server
+}
+
+func (s *Server) newContextTracer(ctx context.Context) (opentracing.Tracer, error) {
+	return httptrace.NewContextTracer(ctx, s.serviceName, s.hostPort, s.tracerOptions...)
+}
+
+// startSpan starts the server span for a call as a child of any trace found
+// in the incoming metadata. The returned context contains the span. A nil span
+// is returned if no tracer could be constructed.
+func (s *Server) startSpan(ctx context.Context, method string) (context.Context, opentracing.Span) {
+	if s.logger != nil {
+		ctx = logevent.NewContext(ctx, s.logger)
+	}
+	var tracer, err = s.newTracer(ctx)
+	if err != nil {
+		s.errorHandler(ctx, err)
+		return ctx, nil
+	}
+	var md, _ = metadata.FromIncomingContext(ctx)
+	var startOptions []opentracing.StartSpanOption
+	var wireContext, er = tracer.Extract(opentracing.TextMap, metadataCarrier(md))
+	if er == nil {
+		startOptions = append(startOptions, opentracing.ChildOf(wireContext))
+	}
+	var span = tracer.StartSpan(s.serviceName, startOptions...)
+	ext.SpanKindRPCServer.Set(span)
+	span.SetTag(methodTag, method)
+	if er != nil && er != opentracing.ErrSpanContextNotFound {
+		span.SetTag(extractErrorTag, er.Error())
+	}
+	return httptrace.ContextWithSpan(ctx, span), span
+}
+
+// finishSpan records the outcome of the call and finishes the span.
+func finishSpan(span opentracing.Span, err error) {
+	span.SetTag(statusCodeTag, status.Code(err).String())
+	if err != nil {
+		ext.Error.Set(span, true)
+	}
+	span.Finish()
+}
+
+// UnaryInterceptor returns an interceptor that traces unary calls.
+func (s *Server) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var spanCtx, span = s.startSpan(ctx, info.FullMethod)
+		if span == nil {
+			return handler(ctx, req)
+		}
+		var resp, err = handler(spanCtx, req)
+		finishSpan(span, err)
+		return resp, err
+	}
+}
+
+// StreamInterceptor returns an interceptor that traces streaming calls. The
+// span lasts for the life of the stream.
+func (s *Server) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		var spanCtx, span = s.startSpan(ss.Context(), info.FullMethod)
+		if span == nil {
+			return handler(srv, ss)
+		}
+		var err = handler(srv, &serverStream{ServerStream: ss, ctx: spanCtx})
+		finishSpan(span, err)
+		return err
+	}
+}
+
+// serverStream replaces the context of a stream with one containing the span.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
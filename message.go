@@ -0,0 +1,180 @@
+package httptrace
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/asecurityteam/logevent"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+type messageError struct {
+	Reason    string `logevent:"reason"`
+	Operation string `logevent:"operation"`
+	TraceID   string `logevent:"trace_id"`
+	SpanID    string `logevent:"span_id"`
+	Message   string `logevent:"message,default=message-handler-error"`
+}
+
+// InjectMessage writes the context of the active span, such as the span of the
+// Middleware, into the headers or attributes of a message that is about to be
+// published. Messages published without an active span are left unchanged.
+func InjectMessage(ctx context.Context, headers map[string]string) error {
+	var span = opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return nil
+	}
+	var err = span.Tracer().Inject(span.Context(), opentracing.TextMap, opentracing.TextMapCarrier(headers))
+	if err != nil {
+		atomic.AddUint64(&stats.InjectFailures, 1)
+	}
+	return err
+}
+
+// Consumer traces the handling of messages received from a queue. Each message
+// is handled within a consumer span that follows from the span that published
+// it, which keeps the asynchronous work within the trace of the original
+// request without implying that the request waited for it.
+type Consumer struct {
+	serviceName   string
+	hostPort      string
+	logger        logevent.Logger
+	tracerOptions []TracerOption
+	errorHandler  ErrorHandler
+	newTracer     func(context.Context) (opentracing.Tracer, error)
+}
+
+// ConsumerOption is a configuration setting for the message consumer.
+type ConsumerOption func(*Consumer) *Consumer
+
+// ConsumerOptionServiceName sets the service name annotation of the consumer
+// spans. The default value of this option is MessageConsumer.
+func ConsumerOptionServiceName(name string) ConsumerOption {
+	return func(c *Consumer) *Consumer {
+		c.serviceName = name
+		return c
+	}
+}
+
+// ConsumerOptionHostPort sets host:port annotation used to represent the
+// service in consumer spans. The default value of this option is 0.0.0.0:80.
+func ConsumerOptionHostPort(hostPort string) ConsumerOption {
+	return func(c *Consumer) *Consumer {
+		c.hostPort = hostPort
+		return c
+	}
+}
+
+// ConsumerOptionLogger sets the Logger used to emit spans and handler errors.
+// Consumers often run outside of any request so, by default, the Logger must
+// be contained within the context given to the consumer.
+func ConsumerOptionLogger(logger logevent.Logger) ConsumerOption {
+	return func(c *Consumer) *Consumer {
+		c.logger = logger
+		return c
+	}
+}
+
+// ConsumerOptionTracerOptions sets the options used when constructing the
+// tracer for each message. As with the Middleware, setting any options means
+// the tracer registered with SetupGlobalTracer is not used.
+func ConsumerOptionTracerOptions(options ...TracerOption) ConsumerOption {
+	return func(c *Consumer) *Consumer {
+		c.tracerOptions = options
+		return c
+	}
+}
+
+// ConsumerOptionTracer sets the tracer used for every message in place of the
+// default zipkin tracer.
+func ConsumerOptionTracer(tracer opentracing.Tracer) ConsumerOption {
+	return func(c *Consumer) *Consumer {
+		c.newTracer = func(context.Context) (opentracing.Tracer, error) {
+			return tracer, nil
+		}
+		return c
+	}
+}
+
+// ConsumerOptionErrorHandler sets the function that receives errors from the
+// tracing infrastructure, such as message headers that cannot be parsed.
+// Messages are always handled regardless of these errors. The default handler
+// logs the first error it receives.
+func ConsumerOptionErrorHandler(handler ErrorHandler) ConsumerOption {
+	return func(c *Consumer) *Consumer {
+		c.errorHandler = handler
+		return c
+	}
+}
+
+// NewConsumer creates a message consumer.
+func NewConsumer(options ...ConsumerOption) *Consumer {
+	var consumer = &Consumer{
+		serviceName:  "MessageConsumer",
+		hostPort:     "0.0.0.0:80",
+		errorHandler: LogErrorOnce(),
+	}
+	consumer.newTracer = consumer.newContextTracer
+	for _, option := range options {
+		consumer = option(consumer)
+	}
+	return consumer
+}
+
+func (c *Consumer) newContextTracer(ctx context.Context) (opentracing.Tracer, error) {
+	return NewContextTracer(ctx, c.serviceName, c.hostPort, c.tracerOptions...)
+}
+
+// StartSpan starts a consumer span with the given operation name for a message
+// with the given headers or attributes. The span follows from the span that
+// published the message, if the headers contain one, and is otherwise the root
+// of a new trace. The returned context contains the span and, when the
+// consumer has a Logger, that Logger. The caller must finish the span. A nil
+// span is returned if no tracer could be constructed.
+func (c *Consumer) StartSpan(ctx context.Context, operation string, headers map[string]string) (context.Context, opentracing.Span) {
+	if c.logger != nil {
+		ctx = logevent.NewContext(ctx, c.logger)
+	}
+	var tracer, err = c.newTracer(ctx)
+	if err != nil {
+		c.errorHandler(ctx, err)
+		return ctx, nil
+	}
+	var startOptions []opentracing.StartSpanOption
+	var wireContext, er = tracer.Extract(opentracing.TextMap, opentracing.TextMapCarrier(headers))
+	if er == nil {
+		startOptions = append(startOptions, opentracing.FollowsFrom(wireContext))
+	}
+	var span = tracer.StartSpan(operation, startOptions...)
+	ext.SpanKindConsumer.Set(span)
+	if er != nil && er != opentracing.ErrSpanContextNotFound {
+		atomic.AddUint64(&stats.ExtractFailures, 1)
+		span.SetTag(extractErrorTag, er.Error())
+		c.errorHandler(ctx, er)
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// Handle runs the handler for a message within a consumer span that is
+// finished when the handler returns. An error returned by the handler marks
+// the span as failed and is logged, along with the trace and span identifiers,
+// before being returned to the caller.
+func (c *Consumer) Handle(ctx context.Context, operation string, headers map[string]string, handler func(context.Context) error) error {
+	var spanCtx, span = c.StartSpan(ctx, operation, headers)
+	if span == nil {
+		return handler(spanCtx)
+	}
+	defer span.Finish()
+	var err = handler(spanCtx)
+	if err != nil {
+		ext.Error.Set(span, true)
+		logevent.FromContext(spanCtx).Error(messageError{
+			Reason:    err.Error(),
+			Operation: operation,
+			TraceID:   TraceIDFromContext(spanCtx),
+			SpanID:    SpanIDFromContext(spanCtx),
+		})
+	}
+	return err
+}
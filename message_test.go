@@ -0,0 +1,80 @@
+package httptrace
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+)
+
+func TestMessagePropagation(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var recorder = NewRecorder()
+	var tracer, _ = NewTracer(nil, "producer", "127.0.0.1:8080", TracerOptionReporter(recorder))
+	var producer = tracer.StartSpan("producer")
+	var headers = map[string]string{}
+	if err := InjectMessage(ContextWithSpan(context.Background(), producer), headers); err != nil {
+		t.Fatal(err)
+	}
+	producer.Finish()
+
+	var logger = NewMockLogger(ctrl)
+	logger.EXPECT().Error(gomock.Any()).Do(func(event interface{}) {
+		var evt, ok = event.(messageError)
+		if !ok {
+			t.Fatal("did not log a message error")
+		}
+		if evt.Reason != "handler failure" || evt.Operation != "consume" {
+			t.Errorf("unexpected error event %v", evt)
+		}
+		if evt.TraceID != producer.Context().(spanContext).TraceID.String() {
+			t.Errorf("expected trace %s but logged %s", producer.Context().(spanContext).TraceID, evt.TraceID)
+		}
+	})
+	var consumer = NewConsumer(
+		ConsumerOptionLogger(logger),
+		ConsumerOptionTracerOptions(TracerOptionReporter(recorder)),
+	)
+	var handlerErr = errors.New("handler failure")
+	var called bool
+	var err = consumer.Handle(context.Background(), "consume", headers, func(ctx context.Context) error {
+		called = true
+		if TraceIDFromContext(ctx) != producer.Context().(spanContext).TraceID.String() {
+			t.Error("handler context is not within the producer trace")
+		}
+		return handlerErr
+	})
+	if !called {
+		t.Error("consumer did not call the handler")
+	}
+	if err != handlerErr {
+		t.Errorf("expected the handler error but got %v", err)
+	}
+
+	var spans = recorder.SpansNamed("consume")
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 consumer span but got %d", len(spans))
+	}
+	recorder.AssertChildOf(t, spans[0], recorder.SpansNamed("producer")[0])
+	recorder.AssertTag(t, spans[0], "span.kind", "consumer")
+	recorder.AssertTag(t, spans[0], "error", "true")
+}
+
+func TestMessageWithoutTrace(t *testing.T) {
+	var headers = map[string]string{}
+	if err := InjectMessage(context.Background(), headers); err != nil || len(headers) != 0 {
+		t.Errorf("expected no headers and no error but got %v and %v", headers, err)
+	}
+
+	var recorder = NewRecorder()
+	var consumer = NewConsumer(ConsumerOptionTracerOptions(TracerOptionReporter(recorder)))
+	var ctx, span = consumer.StartSpan(context.Background(), "consume", headers)
+	span.Finish()
+	var spans = recorder.SpansInTrace(TraceIDFromContext(ctx))
+	if len(spans) != 1 || spans[0].ParentID != nil {
+		t.Error("expected a new root consumer span")
+	}
+}
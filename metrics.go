@@ -0,0 +1,86 @@
+package httptrace
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+// SpanObservation describes a finished server or client span.
+type SpanObservation struct {
+	// Name is the operation name of the span.
+	Name string
+	// Kind is either server or client.
+	Kind string
+	// Duration is the time taken by the span.
+	Duration time.Duration
+	// Error is true when the span was tagged as an error or recorded an HTTP
+	// status of 500 or above.
+	Error bool
+}
+
+// SpanMetrics receives an observation for every finished server and client
+// span so that request rate, error, and duration metrics can be derived from
+// the tracing already performed by the Middleware and Transport.
+// Implementations must be safe for concurrent use.
+type SpanMetrics interface {
+	ObserveSpan(SpanObservation)
+}
+
+// TracerOptionMetrics sends an observation of every finished server and client
+// span to the given metrics. Observations are made for all spans, including
+// those that were not sampled and those later dropped by tail sampling, so that
+// the metrics count every request.
+func TracerOptionMetrics(metrics SpanMetrics) TracerOption {
+	return func(t *tracer) *tracer {
+		t.metrics = append(t.metrics, metrics)
+		return t
+	}
+}
+
+// tagMetrics records the kind and HTTP status of a span for its metrics
+// only. The legacy log format leaves both out of the span of the Middleware so
+// they are given to the metrics in this way instead. A status of 0 is ignored.
+func tagMetrics(s opentracing.Span, kind model.Kind, status int) {
+	var zs, ok = s.(*span)
+	if !ok {
+		return
+	}
+	zs.lock.Lock()
+	defer zs.lock.Unlock()
+	zs.metricsKind = kind
+	if status != 0 {
+		zs.metricsStatus = status
+	}
+}
+
+// observeSpan converts a finished span into an observation for each of the
+// metrics. The kind and status recorded with tagMetrics are used when the span
+// has none. Spans that are neither server nor client spans are ignored.
+func observeSpan(metrics []SpanMetrics, s model.SpanModel, kind model.Kind, status int) {
+	if s.Kind != "" {
+		kind = s.Kind
+	}
+	if kind != model.Server && kind != model.Client {
+		return
+	}
+	var observation = SpanObservation{
+		Name:     s.Name,
+		Kind:     strings.ToLower(string(kind)),
+		Duration: s.Duration,
+		Error:    s.Tags[string(ext.Error)] == "true",
+	}
+	if code, err := strconv.Atoi(s.Tags[string(ext.HTTPStatusCode)]); err == nil {
+		status = code
+	}
+	if status >= 500 {
+		observation.Error = true
+	}
+	for _, m := range metrics {
+		m.ObserveSpan(observation)
+	}
+}
@@ -0,0 +1,113 @@
+package httptrace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	var metrics = NewPrometheusMetrics(10*time.Millisecond, time.Second)
+	var handler = NewMiddleware(
+		MiddlewareOptionServiceName("testservice"),
+		MiddlewareOptionTracerOptions(TracerOptionReporter(NewRecorder()), TracerOptionMetrics(metrics)),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req, _ = http.NewRequest("GET", "/path", nil)
+		var client = NewTransport()(&fixtureTransport{Response: &http.Response{StatusCode: http.StatusBadGateway}})
+		_, _ = client.RoundTrip(req.WithContext(r.Context()))
+	}))
+	for x := 0; x < 2; x = x + 1 {
+		var r, _ = http.NewRequest("GET", "/", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	metrics.ObserveSpan(SpanObservation{Name: `quoted "name"`, Kind: "client", Duration: 100 * time.Millisecond})
+
+	var w = httptest.NewRecorder()
+	var r, _ = http.NewRequest("GET", "/metrics", nil)
+	metrics.ServeHTTP(w, r)
+	var body = w.Body.String()
+	for _, line := range []string{
+		`httptrace_spans_total{kind="server",name="testservice"} 2`,
+		`httptrace_spans_total{kind="client",name="OutgoingHTTPRequest"} 2`,
+		`httptrace_span_errors_total{kind="server",name="testservice"} 0`,
+		`httptrace_span_errors_total{kind="client",name="OutgoingHTTPRequest"} 2`,
+		`httptrace_span_duration_seconds_bucket{kind="client",name="OutgoingHTTPRequest",le="+Inf"} 2`,
+		`httptrace_span_duration_seconds_count{kind="server",name="testservice"} 2`,
+		`httptrace_span_duration_seconds_bucket{kind="client",name="quoted \"name\"",le="0.01"} 0`,
+		`httptrace_span_duration_seconds_bucket{kind="client",name="quoted \"name\"",le="1"} 1`,
+		`httptrace_span_duration_seconds_sum{kind="client",name="quoted \"name\""} 0.1`,
+		"# TYPE httptrace_span_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected line %s in:\n%s", line, body)
+		}
+	}
+}
+
+type fixtureMetrics struct {
+	lock         sync.Mutex
+	observations []SpanObservation
+}
+
+func (m *fixtureMetrics) ObserveSpan(o SpanObservation) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.observations = append(m.observations, o)
+}
+
+func TestMetricsUnsampled(t *testing.T) {
+	var metrics = &fixtureMetrics{}
+	var recorder = NewRecorder()
+	var handler = NewMiddleware(
+		MiddlewareOptionServiceName("testservice"),
+		MiddlewareOptionTracerOptions(TracerOptionReporter(recorder), TracerOptionMetrics(metrics)),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	var r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Set("X-B3-TraceId", "0000000000000001")
+	r.Header.Set("X-B3-SpanId", "0000000000000002")
+	r.Header.Set("X-B3-Sampled", "0")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if len(recorder.Spans()) != 0 {
+		t.Fatalf("expected the unsampled span to be dropped but got %d spans", len(recorder.Spans()))
+	}
+	if len(metrics.observations) != 1 {
+		t.Fatalf("expected 1 observation but got %d", len(metrics.observations))
+	}
+	if o := metrics.observations[0]; o.Name != "testservice" || o.Kind != "server" || !o.Error {
+		t.Errorf("unexpected observation %+v", o)
+	}
+}
+
+func TestMetricsLegacyFormat(t *testing.T) {
+	var metrics = &fixtureMetrics{}
+	var recorder = NewRecorder()
+	var handler = NewMiddleware(
+		MiddlewareOptionServiceName("testservice"),
+		MiddlewareOptionTracerOptions(
+			TracerOptionReporter(recorder),
+			TracerOptionLegacyFormat(true),
+			TracerOptionMetrics(metrics),
+		),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	var r, _ = http.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	var spans = recorder.SpansNamed("testservice")
+	if len(spans) != 1 || spans[0].Kind != "" || len(spans[0].Tags) != 0 {
+		t.Fatalf("expected a single legacy span without a kind or tags but got %+v", spans)
+	}
+	if len(metrics.observations) != 1 {
+		t.Fatalf("expected 1 observation but got %d", len(metrics.observations))
+	}
+	if o := metrics.observations[0]; o.Name != "testservice" || o.Kind != "server" || !o.Error {
+		t.Errorf("unexpected observation %+v", o)
+	}
+}
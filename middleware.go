@@ -2,16 +2,27 @@ package httptrace
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
+	"sync/atomic"
 
 	"github.com/asecurityteam/logevent"
 	opentracing "github.com/opentracing/opentracing-go"
-	zipkin "github.com/openzipkin/zipkin-go-opentracing"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/openzipkin/zipkin-go/model"
 )
 
 type key string
 
+const (
+	extractErrorTag = "extract.error"
+	jaegerHeader    = "Uber-Trace-Id"
+)
+
 var (
 	traceCtxKey = key("httptrace-trace")
 	spanCtxKey  = key("httptrace-span")
@@ -19,34 +30,147 @@ var (
 
 // Middleware adds zipkin style request tracing.
 type Middleware struct {
-	wrapped     http.Handler
-	serviceName string
-	hostPort    string
+	// extractErrors is accessed atomically and must remain the first field
+	// to guarantee 64-bit alignment.
+	extractErrors   uint64
+	wrapped         http.Handler
+	serviceName     string
+	hostPort        string
+	trustPolicy     TrustPolicy
+	recordUntrusted bool
+	extractHandler  func(*http.Request, error)
+	errorHandler    ErrorHandler
+	tracerOptions   []TracerOption
+	traceUpgrades   bool
+	newTracer       func(*http.Request) (opentracing.Tracer, error)
 }
 
 func (h *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var tracer, err = NewTracer(logevent.FromContext(r.Context()), h.serviceName, h.hostPort)
+	var tracer, err = h.newTracer(r)
 	if err != nil {
+		h.errorHandler(r.Context(), err)
 		h.wrapped.ServeHTTP(w, r)
 		return
 	}
 	var ctx = r.Context()
-	var wireContext, er = tracer.Extract(opentracing.TextMap, opentracing.HTTPHeadersCarrier(r.Header))
-	if er != nil {
-		var span = tracer.StartSpan(h.serviceName)
-		defer span.Finish()
-		ctx = context.WithValue(ctx, traceCtxKey, span.Context().(zipkin.SpanContext).TraceID.Low)
-		ctx = context.WithValue(ctx, spanCtxKey, span.Context().(zipkin.SpanContext).SpanID)
-		ctx = opentracing.ContextWithSpan(ctx, span)
-		h.wrapped.ServeHTTP(w, r.WithContext(ctx))
-		return
+	var startOptions []opentracing.StartSpanOption
+	var untrusted opentracing.SpanContext
+	var wireContext, er = tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+	var extractErr error
+	if er != nil && er != opentracing.ErrSpanContextNotFound {
+		extractErr = er
+		atomic.AddUint64(&h.extractErrors, 1)
+		atomic.AddUint64(&stats.ExtractFailures, 1)
+		h.extractHandler(r, er)
 	}
-	var span = tracer.StartSpan(h.serviceName, opentracing.ChildOf(wireContext))
+	if er == nil {
+		if h.trustPolicy(r) {
+			startOptions = append(startOptions, opentracing.ChildOf(wireContext))
+		} else {
+			untrusted = wireContext
+		}
+	}
+	var span = tracer.StartSpan(h.serviceName, startOptions...)
 	defer span.Finish()
-	ctx = context.WithValue(ctx, traceCtxKey, span.Context().(zipkin.SpanContext).TraceID.Low)
-	ctx = context.WithValue(ctx, spanCtxKey, span.Context().(zipkin.SpanContext).SpanID)
-	ctx = opentracing.ContextWithSpan(ctx, span)
-	h.wrapped.ServeHTTP(w, r.WithContext(ctx))
+	var legacy = legacyTracer(tracer)
+	if !legacy {
+		ext.SpanKindRPCServer.Set(span)
+	} else {
+		tagMetrics(span, model.Server, 0)
+	}
+	if extractErr != nil {
+		span.SetTag(extractErrorTag, extractErr.Error())
+	}
+	if untrusted != nil && h.recordUntrusted {
+		tagUntrustedParent(span, untrusted)
+	}
+	var rw = &responseWriter{ResponseWriter: w}
+	if !legacy {
+		rw.onFirstWrite = func() {
+			span.LogFields(log.String("event", firstWriteEvent))
+		}
+	}
+	if h.traceUpgrades {
+		rw.onHijack = func(conn net.Conn) net.Conn {
+			return newTracedConn(conn, span, r)
+		}
+	}
+	h.wrapped.ServeHTTP(wrapResponseWriter(rw), r.WithContext(ContextWithSpan(ctx, span)))
+	if rw.hijacked {
+		span.SetTag(upgradeTag, r.Header.Get("Upgrade"))
+		return
+	}
+	if legacy {
+		tagMetrics(span, model.Server, rw.status)
+		return
+	}
+	if rw.status != 0 {
+		ext.HTTPStatusCode.Set(span, uint16(rw.status))
+	}
+	span.SetTag(responseSizeTag, rw.written)
+	span.SetTag(flushCountTag, rw.flushes)
+}
+
+// ContextWithSpan returns a context that contains the span for use with
+// opentracing.SpanFromContext along with the identifiers of the span for use
+// with TraceIDFromContext and SpanIDFromContext. It is used to make spans
+// created by integrations other than the Middleware available to handlers.
+func ContextWithSpan(ctx context.Context, span opentracing.Span) context.Context {
+	var traceID, spanID = spanContextIDs(span.Tracer(), span.Context())
+	ctx = context.WithValue(ctx, traceCtxKey, traceID)
+	ctx = context.WithValue(ctx, spanCtxKey, spanID)
+	return opentracing.ContextWithSpan(ctx, span)
+}
+
+// newLogTracer generates the tracer for the request using NewContextTracer.
+func (h *Middleware) newLogTracer(r *http.Request) (opentracing.Tracer, error) {
+	return NewContextTracer(r.Context(), h.serviceName, h.hostPort, h.tracerOptions...)
+}
+
+var errNoContextLogger = errors.New("no logevent.Logger in context")
+
+// NewContextTracer generates a tracer that emits spans using the
+// logevent.Logger contained within the context. The Logger is not required
+// when the options select another destination for spans. When no options are
+// given, the tracer registered with SetupGlobalTracer is returned instead if
+// there is one, along with its own service name and host:port. Options always
+// take precedence over the global tracer because it cannot apply them. This is
+// how the Middleware constructs the tracer for each request. An error is
+// returned if the Logger is required but the context contains none.
+func NewContextTracer(ctx context.Context, serviceName string, hostPort string, options ...TracerOption) (opentracing.Tracer, error) {
+	if tracer := GlobalTracer(); tracer != nil && len(options) == 0 {
+		return tracer, nil
+	}
+	var logger logevent.Logger
+	if usesLogger(options) {
+		if logger = loggerFromContext(ctx); logger == nil {
+			return nil, errNoContextLogger
+		}
+	}
+	return NewTracer(logger, serviceName, hostPort, options...)
+}
+
+// legacyTracer reports whether the tracer emits the legacy log format. The
+// span of the middleware is neither marked as a server span nor annotated with
+// details of the response for such tracers in order to keep their output
+// identical to previous versions.
+func legacyTracer(t opentracing.Tracer) bool {
+	var zt, ok = t.(*tracer)
+	return ok && zt.legacyFormat
+}
+
+// legacySpan reports whether the span was started by a tracer that emits the
+// legacy log format.
+func legacySpan(s opentracing.Span) bool {
+	var zs, ok = s.(*span)
+	return ok && zs.tracer.legacyFormat
+}
+
+// ExtractErrors returns the number of incoming requests that carried trace
+// headers which could not be parsed. Requests without any trace headers are
+// not counted.
+func (h *Middleware) ExtractErrors() uint64 {
+	return atomic.LoadUint64(&h.extractErrors)
 }
 
 // MiddlewareOption is a configuration setting for the HTTP middleware.
@@ -72,14 +196,118 @@ func MiddlewareOptionHostPort(hostPort string) MiddlewareOption {
 	}
 }
 
+// MiddlewareOptionTrustPolicy sets the policy used to decide whether the
+// trace headers of an incoming request are trusted. Requests that fail the
+// policy do not join the incoming trace. Instead, a new root span is started
+// so that clients cannot force sampling or debug modes or attach to arbitrary
+// traces. The default policy trusts all requests.
+func MiddlewareOptionTrustPolicy(policy TrustPolicy) MiddlewareOption {
+	return func(m *Middleware) *Middleware {
+		m.trustPolicy = policy
+		return m
+	}
+}
+
+// MiddlewareOptionRecordUntrusted sets whether the identifiers of an untrusted
+// incoming trace are recorded as tags on the new root span. Recording them
+// allows the traces to be correlated manually while keeping them separate.
+// The default value of this option is true.
+func MiddlewareOptionRecordUntrusted(record bool) MiddlewareOption {
+	return func(m *Middleware) *Middleware {
+		m.recordUntrusted = record
+		return m
+	}
+}
+
+// MiddlewareOptionExtractErrorHandler sets a function that is called whenever
+// an incoming request carries trace headers that cannot be parsed. Such
+// requests are still served and traced using a new root span that is tagged
+// with the error. The handler can be used to log or count the failures in
+// order to find the misbehaving upstream. Requests that contain no trace
+// headers at all do not trigger the handler. The default handler does
+// nothing.
+func MiddlewareOptionExtractErrorHandler(handler func(*http.Request, error)) MiddlewareOption {
+	return func(m *Middleware) *Middleware {
+		m.extractHandler = handler
+		return m
+	}
+}
+
+// MiddlewareOptionErrorHandler sets the function that receives errors from the
+// tracing infrastructure, such as a failure to construct a tracer. Requests are
+// always served, untraced if necessary, regardless of these errors. The default
+// handler logs the first error it receives using the logevent.Logger contained
+// within the request context.
+func MiddlewareOptionErrorHandler(handler ErrorHandler) MiddlewareOption {
+	return func(m *Middleware) *Middleware {
+		m.errorHandler = handler
+		return m
+	}
+}
+
+// MiddlewareOptionTracerOptions sets the options used when constructing the
+// tracer for each request. For example, TracerOptionLegacyFormat may be given
+// to keep the log output of previous versions. Setting any options means the
+// middleware builds its own tracer, with its own service name and host:port,
+// even when a tracer is registered with SetupGlobalTracer. Without options the
+// registered tracer takes precedence over the service name and host:port of the
+// middleware.
+func MiddlewareOptionTracerOptions(options ...TracerOption) MiddlewareOption {
+	return func(m *Middleware) *Middleware {
+		m.tracerOptions = options
+		return m
+	}
+}
+
+// MiddlewareOptionTraceUpgrades sets whether connections taken over by the
+// handler, such as WebSockets and other upgraded protocols, are recorded. Each
+// such connection is recorded as a span, started when the connection is
+// hijacked and finished when it is closed, that is a child of the span of the
+// request. The span is tagged with the bytes read and written and the reason
+// the connection ended. The default value of this option is false.
+func MiddlewareOptionTraceUpgrades(trace bool) MiddlewareOption {
+	return func(m *Middleware) *Middleware {
+		m.traceUpgrades = trace
+		return m
+	}
+}
+
+// MiddlewareOptionTracer sets the tracer used for every request in place of
+// the default zipkin tracer. Any opentracing implementation may be used and the
+// context helpers, such as TraceIDFromContext, continue to work. The service
+// name option still sets the operation name of the spans while the host:port
+// and tracer options are ignored.
+func MiddlewareOptionTracer(tracer opentracing.Tracer) MiddlewareOption {
+	return MiddlewareOptionTracerFactory(func(*http.Request) (opentracing.Tracer, error) {
+		return tracer, nil
+	})
+}
+
+// MiddlewareOptionTracerFactory sets a function that produces the tracer used
+// for each incoming request. This allows, for example, a tracer to be selected
+// based on the request or to use values from the request context. Requests for
+// which the factory returns an error are served untraced and the error is sent
+// to the error handler.
+func MiddlewareOptionTracerFactory(factory func(*http.Request) (opentracing.Tracer, error)) MiddlewareOption {
+	return func(m *Middleware) *Middleware {
+		m.newTracer = factory
+		return m
+	}
+}
+
 // NewMiddleware creates a middleware.
 func NewMiddleware(options ...MiddlewareOption) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		var middleware = &Middleware{
-			serviceName: "HTTPService",
-			hostPort:    "0.0.0.0:80",
-			wrapped:     next,
+			serviceName:     "HTTPService",
+			hostPort:        "0.0.0.0:80",
+			trustPolicy:     TrustPolicyAll,
+			recordUntrusted: true,
+			extractHandler:  func(*http.Request, error) {},
+			errorHandler:    LogErrorOnce(),
+			wrapped:         next,
 		}
+		middleware.newTracer = middleware.newLogTracer
 		for _, option := range options {
 			middleware = option(middleware)
 		}
@@ -89,10 +317,52 @@ func NewMiddleware(options ...MiddlewareOption) func(http.Handler) http.Handler
 
 // TraceIDFromContext returns the active TraceID value as a string.
 func TraceIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(traceCtxKey).(string); ok {
+		return id
+	}
 	return fmt.Sprintf("%016x", ctx.Value(traceCtxKey))
 }
 
 // SpanIDFromContext returns the active TraceID value as a string.
 func SpanIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(spanCtxKey).(string); ok {
+		return id
+	}
 	return fmt.Sprintf("%016x", ctx.Value(spanCtxKey))
 }
+
+// spanContextIDs returns the hex encoded trace and span identifiers of a span
+// context. Zipkin contexts are read directly. Contexts of any other tracer are
+// injected as HTTP headers in order to read them in their wire format.
+func spanContextIDs(tracer opentracing.Tracer, sc opentracing.SpanContext) (string, string) {
+	if zsc, ok := sc.(spanContext); ok {
+		return fmt.Sprintf("%016x", zsc.TraceID.Low), fmt.Sprintf("%016x", uint64(zsc.ID))
+	}
+	var header = http.Header{}
+	_ = tracer.Inject(sc, opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+	return headerIDs(header)
+}
+
+// headerIDs reads the trace and span identifiers from headers written by a
+// tracer. B3 and Jaeger headers are recognised first. Otherwise, any headers
+// whose names end in traceid and spanid are used, which covers the prefixed
+// headers of tracers such as the opentracing mocktracer.
+func headerIDs(header http.Header) (string, string) {
+	if traceID := header.Get(b3TraceIDHeader); traceID != "" {
+		return traceID, header.Get(b3SpanIDHeader)
+	}
+	if parts := strings.Split(header.Get(jaegerHeader), ":"); len(parts) == 4 {
+		return parts[0], parts[1]
+	}
+	var traceID, spanID string
+	for name := range header {
+		var lower = strings.ToLower(strings.Replace(name, "-", "", -1))
+		switch {
+		case strings.HasSuffix(lower, "traceid"):
+			traceID = header.Get(name)
+		case strings.HasSuffix(lower, "spanid") && !strings.HasSuffix(lower, "parentspanid"):
+			spanID = header.Get(name)
+		}
+	}
+	return traceID, spanID
+}
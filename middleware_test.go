@@ -2,12 +2,16 @@ package httptrace
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 
 	"github.com/asecurityteam/logevent"
 	"github.com/golang/mock/gomock"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 )
 
 type fixtureHandler struct {
@@ -29,9 +33,9 @@ func TestMiddlewareGeneratesNewSpans(t *testing.T) {
 
 	var logger = NewMockLogger(ctrl)
 	logger.EXPECT().Info(gomock.Any()).Do(func(event interface{}) {
-		var evt frame
+		var evt v2Frame
 		var ok bool
-		if evt, ok = event.(frame); !ok {
+		if evt, ok = event.(v2Frame); !ok {
 			t.Error("did not log a zipkin frame")
 		}
 		if evt.Zipkin.ParentID != "" {
@@ -59,9 +63,9 @@ func TestMiddlewareGeneratesAdoptsHeaders(t *testing.T) {
 
 	var logger = NewMockLogger(ctrl)
 	logger.EXPECT().Info(gomock.Any()).Do(func(event interface{}) {
-		var evt frame
+		var evt v2Frame
 		var ok bool
-		if evt, ok = event.(frame); !ok {
+		if evt, ok = event.(v2Frame); !ok {
 			t.Error("did not log a zipkin frame")
 		}
 		if evt.Zipkin.ParentID != "0000000000000002" {
@@ -86,3 +90,193 @@ func TestMiddlewareGeneratesAdoptsHeaders(t *testing.T) {
 		t.Error("middleware did not call the wrapped handler")
 	}
 }
+
+func TestMiddlewareReRootsUntrustedHeaders(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var w = httptest.NewRecorder()
+	var r, _ = http.NewRequest("GET", "/", nil)
+
+	var logger = NewMockLogger(ctrl)
+	logger.EXPECT().Info(gomock.Any()).Do(func(event interface{}) {
+		var evt v2Frame
+		var ok bool
+		if evt, ok = event.(v2Frame); !ok {
+			t.Error("did not log a zipkin frame")
+		}
+		if evt.Zipkin.ParentID != "" {
+			t.Errorf("unexpected parent span %s", evt.Zipkin.ParentID)
+		}
+		if evt.Zipkin.TraceID == "0000000000000001" {
+			t.Error("joined an untrusted trace")
+		}
+		var tags = evt.Zipkin.Tags
+		if tags[untrustedTraceIDTag] != "0000000000000001" {
+			t.Errorf("expected untrusted trace 0000000000000001 but found %s", tags[untrustedTraceIDTag])
+		}
+		if tags[untrustedSpanIDTag] != "0000000000000002" {
+			t.Errorf("expected untrusted span 0000000000000002 but found %s", tags[untrustedSpanIDTag])
+		}
+	})
+	var wrapped = fixtureHandler{}
+	var handler = NewMiddleware(
+		MiddlewareOptionServiceName("testservice"),
+		MiddlewareOptionHostPort("localhost:8080"),
+		MiddlewareOptionTrustPolicy(TrustPolicyNone),
+	)(&wrapped)
+	r.Header.Set("X-B3-TraceId", "0000000000000001")
+	r.Header.Set("X-B3-SpanId", "0000000000000002")
+	r.Header.Set("X-B3-Sampled", "1")
+	handler.ServeHTTP(w, r.WithContext(logevent.NewContext(r.Context(), logger)))
+
+	if !wrapped.called {
+		t.Error("middleware did not call the wrapped handler")
+	}
+}
+
+func TestMiddlewareReportsMalformedHeaders(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var w = httptest.NewRecorder()
+	var r, _ = http.NewRequest("GET", "/", nil)
+
+	var logger = NewMockLogger(ctrl)
+	logger.EXPECT().Info(gomock.Any()).Do(func(event interface{}) {
+		var evt = event.(v2Frame)
+		if evt.Zipkin.ParentID != "" {
+			t.Errorf("unexpected parent span %s", evt.Zipkin.ParentID)
+		}
+		if evt.Zipkin.Tags[extractErrorTag] == "" {
+			t.Error("root span was not tagged with the extract error")
+		}
+	})
+	var wrapped = fixtureHandler{}
+	var handlerErr error
+	var handler = NewMiddleware(
+		MiddlewareOptionExtractErrorHandler(func(_ *http.Request, err error) {
+			handlerErr = err
+		}),
+	)(&wrapped)
+	r.Header.Set("X-B3-TraceId", "not-hex")
+	r.Header.Set("X-B3-SpanId", "0000000000000002")
+	handler.ServeHTTP(w, r.WithContext(logevent.NewContext(r.Context(), logger)))
+
+	if !wrapped.called {
+		t.Error("middleware did not call the wrapped handler")
+	}
+	if handlerErr == nil {
+		t.Error("extract error handler was not called")
+	}
+	if count := handler.(*Middleware).ExtractErrors(); count != 1 {
+		t.Errorf("expected 1 extract error but counted %d", count)
+	}
+}
+
+func TestMiddlewareIgnoresMissingHeaders(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var w = httptest.NewRecorder()
+	var r, _ = http.NewRequest("GET", "/", nil)
+
+	var logger = NewMockLogger(ctrl)
+	logger.EXPECT().Info(gomock.Any())
+	var wrapped = fixtureHandler{}
+	var handler = NewMiddleware(
+		MiddlewareOptionExtractErrorHandler(func(_ *http.Request, err error) {
+			t.Errorf("unexpected extract error %s", err)
+		}),
+	)(&wrapped)
+	handler.ServeHTTP(w, r.WithContext(logevent.NewContext(r.Context(), logger)))
+
+	if count := handler.(*Middleware).ExtractErrors(); count != 0 {
+		t.Errorf("expected no extract errors but counted %d", count)
+	}
+}
+
+func TestMiddlewareReportsTracerErrors(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var w = httptest.NewRecorder()
+	var r, _ = http.NewRequest("GET", "/", nil)
+
+	var logger = NewMockLogger(ctrl)
+	var wrapped = fixtureHandler{}
+	var handlerErr error
+	var handler = NewMiddleware(
+		MiddlewareOptionErrorHandler(func(_ context.Context, err error) {
+			handlerErr = err
+		}),
+		MiddlewareOptionTracerFactory(func(*http.Request) (opentracing.Tracer, error) {
+			return nil, errors.New("tracer failure")
+		}),
+	)(&wrapped)
+	handler.ServeHTTP(w, r.WithContext(logevent.NewContext(r.Context(), logger)))
+
+	if !wrapped.called {
+		t.Error("middleware did not call the wrapped handler")
+	}
+	if handlerErr == nil {
+		t.Error("error handler was not called")
+	}
+}
+
+func TestMiddlewareLegacyFormat(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var w = httptest.NewRecorder()
+	var r, _ = http.NewRequest("GET", "/", nil)
+
+	var logger = NewMockLogger(ctrl)
+	logger.EXPECT().Info(gomock.Any()).Do(func(event interface{}) {
+		if _, ok := event.(frame); !ok {
+			t.Error("did not log a legacy zipkin frame")
+		}
+	})
+	var wrapped = fixtureHandler{}
+	var handler = NewMiddleware(
+		MiddlewareOptionTracerOptions(TracerOptionLegacyFormat(true)),
+	)(&wrapped)
+	handler.ServeHTTP(w, r.WithContext(logevent.NewContext(r.Context(), logger)))
+
+	if !wrapped.called {
+		t.Error("middleware did not call the wrapped handler")
+	}
+}
+
+func TestMiddlewareOptionTracer(t *testing.T) {
+	var w = httptest.NewRecorder()
+	var r, _ = http.NewRequest("GET", "/", nil)
+
+	var tracer = mocktracer.New()
+	var parent = tracer.StartSpan("parent")
+	_ = tracer.Inject(parent.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+	var wrapped = fixtureHandler{}
+	var handler = NewMiddleware(
+		MiddlewareOptionServiceName("testservice"),
+		MiddlewareOptionTracer(tracer),
+	)(&wrapped)
+	handler.ServeHTTP(w, r)
+
+	var spans = tracer.FinishedSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 finished span but got %d", len(spans))
+	}
+	var span = spans[0]
+	if span.OperationName != "testservice" {
+		t.Errorf("expected operation testservice but got %s", span.OperationName)
+	}
+	if span.ParentID != parent.Context().(mocktracer.MockSpanContext).SpanID {
+		t.Error("span did not adopt the incoming trace")
+	}
+	if id := TraceIDFromContext(wrapped.ctx); id != strconv.Itoa(span.SpanContext.TraceID) {
+		t.Errorf("expected trace %d but found %s", span.SpanContext.TraceID, id)
+	}
+	if id := SpanIDFromContext(wrapped.ctx); id != strconv.Itoa(span.SpanContext.SpanID) {
+		t.Errorf("expected span %d but found %s", span.SpanContext.SpanID, id)
+	}
+}
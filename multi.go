@@ -0,0 +1,97 @@
+package httptrace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+// TracerOptionAddReporter sends finished spans to the given zipkin-go Reporter
+// in addition to the Logger, or to the reporter set with TracerOptionReporter.
+// For example, a reporter from the zipkin-go reporter/http package may be added
+// to export spans to Zipkin while keeping the span logs. The option may be
+// given more than once.
+func TracerOptionAddReporter(r reporter.Reporter) TracerOption {
+	return func(t *tracer) *tracer {
+		t.extraReporters = append(t.extraReporters, r)
+		return t
+	}
+}
+
+// multiReporter implements the zipkin-go Reporter interface by sending each
+// span to every one of a set of reporters.
+type multiReporter struct {
+	reporters    []reporter.Reporter
+	errorHandler ErrorHandler
+}
+
+// NewMultiReporter creates a zipkin-go Reporter that sends every span to each
+// of the given reporters. A reporter that panics does not prevent the others
+// from receiving the span. The panic is converted into an error and given to
+// the error handler, if it is not nil, along with a context that contains no
+// Logger. Closing the returned reporter closes all of the reporters and returns
+// the errors of any that failed.
+func NewMultiReporter(errorHandler ErrorHandler, reporters ...reporter.Reporter) reporter.Reporter {
+	if errorHandler == nil {
+		errorHandler = func(context.Context, error) {}
+	}
+	return &multiReporter{reporters: reporters, errorHandler: errorHandler}
+}
+
+func (m *multiReporter) Send(s model.SpanModel) {
+	m.SendWithLogs(s, nil)
+}
+
+// SendWithLogs forwards the fields of the span logs to the reporters that use
+// them.
+func (m *multiReporter) SendWithLogs(s model.SpanModel, logs [][]log.Field) {
+	for _, r := range m.reporters {
+		m.isolate(func() { sendSpan(r, s, logs) })
+	}
+}
+
+// FinishTrace forwards the completion of a trace to the reporters that act on
+// complete traces.
+func (m *multiReporter) FinishTrace(id model.TraceID) {
+	for _, r := range m.reporters {
+		if tr, ok := r.(traceReporter); ok {
+			m.isolate(func() { tr.FinishTrace(id) })
+		}
+	}
+}
+
+func (m *multiReporter) Close() error {
+	var errs []error
+	for _, r := range m.reporters {
+		if err := closeReporter(r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isolate runs the function and reports any panic to the error handler.
+func (m *multiReporter) isolate(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddUint64(&stats.CollectorErrors, 1)
+			m.errorHandler(context.Background(), fmt.Errorf("reporter panic: %v", r))
+		}
+	}()
+	f()
+}
+
+// closeReporter closes the reporter and converts any panic into an error.
+func closeReporter(r reporter.Reporter) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("reporter panic: %v", p)
+		}
+	}()
+	return r.Close()
+}
@@ -0,0 +1,81 @@
+package httptrace
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+type fixtureReporter struct {
+	sent     int
+	panics   bool
+	closeErr error
+}
+
+func (r *fixtureReporter) Send(model.SpanModel) {
+	if r.panics {
+		panic("send failure")
+	}
+	r.sent = r.sent + 1
+}
+
+func (r *fixtureReporter) Close() error {
+	if r.panics {
+		panic("close failure")
+	}
+	return r.closeErr
+}
+
+func TestMultiReporterIsolatesFailures(t *testing.T) {
+	var failing = &fixtureReporter{panics: true}
+	var closeErr = errors.New("close failure")
+	var working = &fixtureReporter{closeErr: closeErr}
+	var handled []error
+	var multi = NewMultiReporter(func(_ context.Context, err error) {
+		handled = append(handled, err)
+	}, failing, working)
+
+	multi.Send(model.SpanModel{})
+	if working.sent != 1 {
+		t.Errorf("expected 1 span sent to the working reporter but got %d", working.sent)
+	}
+	if len(handled) != 1 {
+		t.Errorf("expected 1 handled error but got %d", len(handled))
+	}
+	var err = multi.Close()
+	if !errors.Is(err, closeErr) {
+		t.Errorf("expected the close error of the working reporter but got %v", err)
+	}
+	if err == nil || err.Error() == closeErr.Error() {
+		t.Errorf("expected the close errors of both reporters but got %v", err)
+	}
+}
+
+func TestMultiReporterLogErrorOnce(t *testing.T) {
+	var failing = &fixtureReporter{panics: true}
+	var working = &fixtureReporter{}
+	var multi = NewMultiReporter(LogErrorOnce(), failing, working)
+
+	multi.Send(model.SpanModel{})
+	multi.Send(model.SpanModel{})
+	if working.sent != 2 {
+		t.Errorf("expected 2 spans sent to the working reporter but got %d", working.sent)
+	}
+}
+
+func TestTracerAddReporter(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var logger = NewMockLogger(ctrl)
+	logger.EXPECT().Info(gomock.Any())
+	var recorder = NewRecorder()
+	var tr, _ = NewTracer(logger, "testservice", "127.0.0.1:8080", TracerOptionAddReporter(recorder))
+	tr.StartSpan("test").Finish()
+	if len(recorder.Spans()) != 1 {
+		t.Errorf("expected 1 span in the added reporter but got %d", len(recorder.Spans()))
+	}
+}
@@ -0,0 +1,29 @@
+module github.com/asecurityteam/httptrace/oteltrace
+
+go 1.23.0
+
+require (
+	github.com/asecurityteam/httptrace v0.0.0-20261019061343-45d93b3ad9fc
+	github.com/opentracing/opentracing-go v1.2.0
+	go.opentelemetry.io/contrib/propagators/b3 v1.36.0
+	go.opentelemetry.io/otel/bridge/opentracing v1.36.0
+	go.opentelemetry.io/otel/sdk v1.36.0
+	go.opentelemetry.io/otel/trace v1.36.0
+)
+
+require (
+	github.com/asecurityteam/logevent v1.4.0 // indirect
+	github.com/fatih/structs v1.1.0 // indirect
+	github.com/go-logfmt/logfmt v0.6.1 // indirect
+	github.com/go-logr/logr v1.4.4 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/openzipkin/zipkin-go v0.4.3 // indirect
+	github.com/rs/zerolog v1.15.0 // indirect
+	go.opentelemetry.io/auto/sdk v1.1.0 // indirect
+	go.opentelemetry.io/otel v1.36.0 // indirect
+	go.opentelemetry.io/otel/metric v1.36.0 // indirect
+	go.yaml.in/yaml/v3 v3.0.5 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	google.golang.org/grpc v1.71.1 // indirect
+)
@@ -0,0 +1,55 @@
+// Package oteltrace allows services that have adopted OpenTelemetry to keep
+// using the httptrace Middleware and Transport. It is a separate module so
+// that users of httptrace do not depend on OpenTelemetry.
+package oteltrace
+
+import (
+	"net/http"
+
+	"github.com/asecurityteam/httptrace"
+	opentracing "github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	otelbridge "go.opentelemetry.io/otel/bridge/opentracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/asecurityteam/httptrace"
+
+// newBridgeTracer generates an opentracing.Tracer implementation that creates
+// spans using the given OpenTelemetry TracerProvider. The tracer propagates
+// context using B3 headers in order to remain compatible with services that
+// use the zipkin tracer. Spans placed in a context with
+// opentracing.ContextWithSpan are also made active for OpenTelemetry so that
+// any OpenTelemetry instrumentation used by a handler joins the same trace.
+func newBridgeTracer(provider trace.TracerProvider) *otelbridge.BridgeTracer {
+	var bridge, wrapper = otelbridge.NewTracerPair(provider.Tracer(instrumentationName))
+	bridge.SetOpenTelemetryTracer(wrapper.Tracer(instrumentationName))
+	bridge.SetTextMapPropagator(b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+	return bridge
+}
+
+// MiddlewareOptionTracerProvider configures the middleware to create spans
+// through the given OpenTelemetry TracerProvider rather than the default
+// zipkin tracer. Spans are then exported by the provider instead of being
+// written to the logs. B3 headers are still used for propagation and the
+// context helpers, such as TraceIDFromContext, continue to work. The Transport
+// uses the tracer of the active span and so needs no further configuration.
+func MiddlewareOptionTracerProvider(provider trace.TracerProvider) httptrace.MiddlewareOption {
+	return httptrace.MiddlewareOptionTracer(newBridgeTracer(provider))
+}
+
+// TransportOptionTracerProvider allows the transport to trace requests made
+// from code that uses OpenTelemetry directly. When a request context contains
+// no opentracing span but does contain a valid OpenTelemetry span then the
+// client span is created through the given TracerProvider as a child of that
+// span. Requests made within the Middleware are unaffected by this option.
+func TransportOptionTracerProvider(provider trace.TracerProvider) httptrace.TransportOption {
+	var tracer = newBridgeTracer(provider)
+	return httptrace.TransportOptionParentSpan(func(r *http.Request) opentracing.Span {
+		var span = trace.SpanFromContext(r.Context())
+		if !span.SpanContext().IsValid() {
+			return nil
+		}
+		return opentracing.SpanFromContext(tracer.ContextWithBridgeSpan(r.Context(), span))
+	})
+}
@@ -0,0 +1,97 @@
+package oteltrace
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/asecurityteam/httptrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type fixtureTransport struct {
+	Response *http.Response
+	Request  *http.Request
+}
+
+func (c *fixtureTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.Request = r
+	return c.Response, nil
+}
+
+func TestMiddlewareTracerProvider(t *testing.T) {
+	var recorder = tracetest.NewSpanRecorder()
+	var provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	var w = httptest.NewRecorder()
+	var r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Set("X-B3-TraceId", "00000000000000000000000000000001")
+	r.Header.Set("X-B3-SpanId", "0000000000000002")
+	r.Header.Set("X-B3-Sampled", "1")
+
+	var downstream = &fixtureTransport{Response: &http.Response{StatusCode: http.StatusOK}}
+	var transport = httptrace.NewTransport()(downstream)
+	var traceID, spanID string
+	var handler = httptrace.NewMiddleware(
+		httptrace.MiddlewareOptionServiceName("testservice"),
+		MiddlewareOptionTracerProvider(provider),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID = httptrace.TraceIDFromContext(r.Context())
+		spanID = httptrace.SpanIDFromContext(r.Context())
+		var req, _ = http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		_, _ = transport.RoundTrip(req.WithContext(r.Context()))
+	}))
+	handler.ServeHTTP(w, r)
+
+	var spans = recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans but found %d", len(spans))
+	}
+	var client, server = spans[0], spans[1]
+	if server.Name() != "testservice" {
+		t.Errorf("expected server span testservice but found %s", server.Name())
+	}
+	if server.Parent().SpanID().String() != "0000000000000002" {
+		t.Errorf("expected parent 0000000000000002 but found %s", server.Parent().SpanID())
+	}
+	if traceID != "00000000000000000000000000000001" || traceID != server.SpanContext().TraceID().String() {
+		t.Errorf("unexpected trace id in context %s", traceID)
+	}
+	if spanID != server.SpanContext().SpanID().String() {
+		t.Errorf("expected span id %s in context but found %s", server.SpanContext().SpanID(), spanID)
+	}
+	if client.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("client span is not a child of the server span")
+	}
+	if downstream.Request.Header.Get("X-B3-SpanId") != client.SpanContext().SpanID().String() {
+		t.Errorf("client span was not propagated: %v", downstream.Request.Header)
+	}
+}
+
+func TestTransportTracerProvider(t *testing.T) {
+	var recorder = tracetest.NewSpanRecorder()
+	var provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	var ctx, parent = provider.Tracer("test").Start(context.Background(), "parent")
+
+	var downstream = &fixtureTransport{Response: &http.Response{StatusCode: http.StatusOK}}
+	var transport = httptrace.NewTransport(
+		httptrace.TransportOptionSpanName("TESTSPAN"),
+		TransportOptionTracerProvider(provider),
+	)(downstream)
+	var req, _ = http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	_, _ = transport.RoundTrip(req.WithContext(ctx))
+	parent.End()
+
+	var spans = recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans but found %d", len(spans))
+	}
+	if spans[0].Name() != "TESTSPAN" || spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("client span is not a child of the OpenTelemetry span")
+	}
+	if downstream.Request.Header.Get("X-B3-TraceId") != parent.SpanContext().TraceID().String() {
+		t.Errorf("trace was not propagated: %v", downstream.Request.Header)
+	}
+}
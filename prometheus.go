@@ -0,0 +1,142 @@
+package httptrace
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds of the latency histograms used
+// when no buckets are given to NewPrometheusMetrics.
+var DefaultLatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+type seriesKey struct {
+	name string
+	kind string
+}
+
+type series struct {
+	count   uint64
+	errors  uint64
+	sum     time.Duration
+	buckets []uint64
+}
+
+// PrometheusMetrics is a SpanMetrics implementation that keeps a count, an
+// error count, and a latency histogram for each span name and kind. It is also
+// an http.Handler that serves the metrics in the Prometheus text format:
+//
+//	httptrace_spans_total{kind="server",name="my-service"} 10
+//	httptrace_span_errors_total{kind="server",name="my-service"} 1
+//	httptrace_span_duration_seconds_bucket{kind="server",name="my-service",le="0.005"} 4
+type PrometheusMetrics struct {
+	buckets []time.Duration
+	lock    sync.Mutex
+	series  map[seriesKey]*series
+}
+
+// NewPrometheusMetrics creates metrics with histograms using the given bucket
+// upper bounds. DefaultLatencyBuckets are used if none are given.
+func NewPrometheusMetrics(buckets ...time.Duration) *PrometheusMetrics {
+	if len(buckets) < 1 {
+		buckets = DefaultLatencyBuckets
+	}
+	var sorted = append([]time.Duration(nil), buckets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return &PrometheusMetrics{buckets: sorted, series: make(map[seriesKey]*series)}
+}
+
+// ObserveSpan belongs to the SpanMetrics interface.
+func (m *PrometheusMetrics) ObserveSpan(o SpanObservation) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var key = seriesKey{name: o.Name, kind: o.Kind}
+	var s, ok = m.series[key]
+	if !ok {
+		s = &series{buckets: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+	s.count = s.count + 1
+	if o.Error {
+		s.errors = s.errors + 1
+	}
+	s.sum = s.sum + o.Duration
+	for offset, bound := range m.buckets {
+		if o.Duration <= bound {
+			s.buckets[offset] = s.buckets[offset] + 1
+		}
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(m.render())
+}
+
+func (m *PrometheusMetrics) render() []byte {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var keys = make([]seriesKey, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].kind < keys[j].kind
+	})
+	var buf = bytes.NewBuffer(nil)
+	fmt.Fprintln(buf, "# HELP httptrace_spans_total Number of finished server and client spans.")
+	fmt.Fprintln(buf, "# TYPE httptrace_spans_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(buf, "httptrace_spans_total{%s} %d\n", labels(key, ""), m.series[key].count)
+	}
+	fmt.Fprintln(buf, "# HELP httptrace_span_errors_total Number of finished server and client spans that failed.")
+	fmt.Fprintln(buf, "# TYPE httptrace_span_errors_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(buf, "httptrace_span_errors_total{%s} %d\n", labels(key, ""), m.series[key].errors)
+	}
+	fmt.Fprintln(buf, "# HELP httptrace_span_duration_seconds Duration of finished server and client spans.")
+	fmt.Fprintln(buf, "# TYPE httptrace_span_duration_seconds histogram")
+	for _, key := range keys {
+		var s = m.series[key]
+		for offset, bound := range m.buckets {
+			var le = strconv.FormatFloat(bound.Seconds(), 'g', -1, 64)
+			fmt.Fprintf(buf, "httptrace_span_duration_seconds_bucket{%s} %d\n", labels(key, le), s.buckets[offset])
+		}
+		fmt.Fprintf(buf, "httptrace_span_duration_seconds_bucket{%s} %d\n", labels(key, "+Inf"), s.count)
+		fmt.Fprintf(buf, "httptrace_span_duration_seconds_sum{%s} %s\n", labels(key, ""), strconv.FormatFloat(s.sum.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(buf, "httptrace_span_duration_seconds_count{%s} %d\n", labels(key, ""), s.count)
+	}
+	return buf.Bytes()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels renders the label set of a series with an optional bucket bound.
+func labels(key seriesKey, le string) string {
+	var result = fmt.Sprintf(`kind="%s",name="%s"`, labelEscaper.Replace(key.kind), labelEscaper.Replace(key.name))
+	if le != "" {
+		result = result + fmt.Sprintf(`,le="%s"`, le)
+	}
+	return result
+}
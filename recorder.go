@@ -0,0 +1,144 @@
+package httptrace
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+// TracerOptionReporter sends finished spans to the given zipkin-go Reporter
+// instead of logging them. The Logger and any log options are not used.
+func TracerOptionReporter(r reporter.Reporter) TracerOption {
+	return func(t *tracer) *tracer {
+		t.customReporter = r
+		return t
+	}
+}
+
+// TestingT is the subset of testing.T used by the Recorder assertions.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// Recorder is a zipkin-go Reporter that keeps finished spans in memory so that
+// tests can inspect the spans produced by the Middleware and Transport. It is
+// installed with TracerOptionReporter:
+//
+//	var recorder = httptrace.NewRecorder()
+//	var middleware = httptrace.NewMiddleware(
+//		httptrace.MiddlewareOptionTracerOptions(httptrace.TracerOptionReporter(recorder)),
+//	)
+//
+// Spans are recorded in the order they finish. The span.kind and peer.service
+// tags are recorded as the Kind and RemoteEndpoint of the span rather than as
+// tags.
+type Recorder struct {
+	lock  sync.Mutex
+	spans []model.SpanModel
+}
+
+// NewRecorder creates an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Send records a finished span.
+func (r *Recorder) Send(s model.SpanModel) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.spans = append(r.spans, s)
+}
+
+// Close belongs to the zipkin-go Reporter interface and does nothing.
+func (r *Recorder) Close() error {
+	return nil
+}
+
+// Reset discards all recorded spans.
+func (r *Recorder) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.spans = nil
+}
+
+// Spans returns all recorded spans.
+func (r *Recorder) Spans() []model.SpanModel {
+	return r.filter(func(model.SpanModel) bool { return true })
+}
+
+// SpansNamed returns the recorded spans with the given name.
+func (r *Recorder) SpansNamed(name string) []model.SpanModel {
+	return r.filter(func(s model.SpanModel) bool { return s.Name == name })
+}
+
+// SpansInTrace returns the recorded spans of the trace with the given hex
+// encoded identifier, such as the value of TraceIDFromContext. Either the full
+// identifier of a 128 bit trace or only its lower 64 bits, which is the form
+// returned by TraceIDFromContext, is accepted.
+func (r *Recorder) SpansInTrace(traceID string) []model.SpanModel {
+	traceID = strings.ToLower(traceID)
+	return r.filter(func(s model.SpanModel) bool {
+		return s.TraceID.String() == traceID || fmt.Sprintf("%016x", s.TraceID.Low) == traceID
+	})
+}
+
+func (r *Recorder) filter(match func(model.SpanModel) bool) []model.SpanModel {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var result = make([]model.SpanModel, 0, len(r.spans))
+	for _, s := range r.spans {
+		if match(s) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// AssertChildOf reports a test error, and returns false, unless the child span
+// is a direct child of the parent span within the same trace.
+func (r *Recorder) AssertChildOf(t TestingT, child model.SpanModel, parent model.SpanModel) bool {
+	if child.TraceID != parent.TraceID {
+		t.Errorf("span %s is in trace %s but parent %s is in trace %s", child.Name, child.TraceID, parent.Name, parent.TraceID)
+		return false
+	}
+	if child.ParentID == nil || *child.ParentID != parent.ID {
+		t.Errorf("span %s is not a child of span %s", child.Name, parent.Name)
+		return false
+	}
+	return true
+}
+
+// AssertTag reports a test error, and returns false, unless the span has the
+// tag with the given value. The span.kind and peer.service tags are compared
+// with the Kind and RemoteEndpoint of the span.
+func (r *Recorder) AssertTag(t TestingT, s model.SpanModel, key string, value string) bool {
+	var actual, ok = spanTag(s, key)
+	if !ok {
+		t.Errorf("span %s has no tag %s", s.Name, key)
+		return false
+	}
+	if actual != value {
+		t.Errorf("span %s has tag %s=%s but expected %s", s.Name, key, actual, value)
+		return false
+	}
+	return true
+}
+
+// spanTag returns the value of the tag with the given key.
+func spanTag(s model.SpanModel, key string) (string, bool) {
+	switch key {
+	case string(ext.SpanKind):
+		return strings.ToLower(string(s.Kind)), s.Kind != ""
+	case string(ext.PeerService):
+		if s.RemoteEndpoint == nil || s.RemoteEndpoint.ServiceName == "" {
+			return "", false
+		}
+		return s.RemoteEndpoint.ServiceName, true
+	}
+	var value, ok = s.Tags[key]
+	return value, ok
+}
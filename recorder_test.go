@@ -0,0 +1,88 @@
+package httptrace
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fixtureT struct {
+	errors []string
+}
+
+func (t *fixtureT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRecorder(t *testing.T) {
+	var recorder = NewRecorder()
+	var traceID string
+	var handler = NewMiddleware(
+		MiddlewareOptionServiceName("testservice"),
+		MiddlewareOptionTracerOptions(TracerOptionReporter(recorder)),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID = TraceIDFromContext(r.Context())
+		var req, _ = http.NewRequest("GET", "/path", nil)
+		var client = NewTransport()(&fixtureTransport{Response: &http.Response{StatusCode: http.StatusOK}})
+		_, _ = client.RoundTrip(req.WithContext(r.Context()))
+	}))
+	var r, _ = http.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if len(recorder.Spans()) != 2 {
+		t.Fatalf("expected 2 spans but got %d", len(recorder.Spans()))
+	}
+	if len(recorder.SpansInTrace(traceID)) != 2 {
+		t.Errorf("expected 2 spans in trace %s but got %d", traceID, len(recorder.SpansInTrace(traceID)))
+	}
+	var server = recorder.SpansNamed("testservice")
+	var client = recorder.SpansNamed("OutgoingHTTPRequest")
+	if len(server) != 1 || len(client) != 1 {
+		t.Fatalf("expected one server and one client span but got %d and %d", len(server), len(client))
+	}
+	recorder.AssertChildOf(t, client[0], server[0])
+	recorder.AssertTag(t, client[0], "span.kind", "client")
+	recorder.AssertTag(t, client[0], "peer.service", "dependency")
+	recorder.AssertTag(t, client[0], "http.status_code", "200")
+
+	var ft = &fixtureT{}
+	if recorder.AssertChildOf(ft, server[0], client[0]) || len(ft.errors) != 1 {
+		t.Error("AssertChildOf did not fail for a parent span")
+	}
+	if recorder.AssertTag(ft, client[0], "http.method", "POST") || len(ft.errors) != 2 {
+		t.Error("AssertTag did not fail for a mismatched value")
+	}
+	if recorder.AssertTag(ft, server[0], "missing", "") || len(ft.errors) != 3 {
+		t.Error("AssertTag did not fail for a missing tag")
+	}
+
+	recorder.Reset()
+	if len(recorder.Spans()) != 0 {
+		t.Error("Reset did not discard spans")
+	}
+}
+
+func TestRecorderSpansInTrace128Bit(t *testing.T) {
+	var recorder = NewRecorder()
+	var traceID string
+	var handler = NewMiddleware(
+		MiddlewareOptionServiceName("testservice"),
+		MiddlewareOptionTracerOptions(TracerOptionReporter(recorder)),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID = TraceIDFromContext(r.Context())
+	}))
+	var r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Set("X-B3-TraceId", "463ac35c9f6413ad48485a3953bb6124")
+	r.Header.Set("X-B3-SpanId", "a2fb4a1d1a96d312")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	for _, id := range []string{traceID, "463ac35c9f6413ad48485a3953bb6124", "463AC35C9F6413AD48485A3953BB6124"} {
+		if len(recorder.SpansInTrace(id)) != 1 {
+			t.Errorf("expected 1 span in trace %s but got %d", id, len(recorder.SpansInTrace(id)))
+		}
+	}
+	if len(recorder.SpansInTrace("463ac35c9f6413ad")) != 0 {
+		t.Error("matched the upper 64 bits of the trace")
+	}
+}
@@ -0,0 +1,187 @@
+package httptrace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+const (
+	redirectHopTag         = "http.redirect.hop"
+	redirectOriginalURLTag = "http.redirect.original_url"
+	redirectStatusTag      = "http.redirect.status"
+	redirectsTag           = "http.redirects"
+	// maxRedirects matches the default policy of http.Client.
+	maxRedirects = 10
+)
+
+type redirectChainKey struct{}
+type redirectHopKey struct{}
+
+// redirectChain is the logical request shared by the hops of a call that
+// follows redirects.
+type redirectChain struct {
+	// ctx contains the logical span and is the context of every hop. The
+	// context of a previous hop cannot be used because round trippers beneath
+	// this one, such as a Transport that retries, may have replaced it.
+	ctx      context.Context
+	span     opentracing.Span
+	original string
+	hops     int
+	once     sync.Once
+}
+
+// finish records the outcome of the last hop on the logical span and finishes
+// it. Only the first call has any effect.
+func (c *redirectChain) finish(resp *http.Response, err error) {
+	c.once.Do(func() {
+		c.span.SetTag(redirectsTag, c.hops)
+		if resp != nil {
+			ext.HTTPStatusCode.Set(c.span, uint16(resp.StatusCode))
+		}
+		if err != nil && err != http.ErrUseLastResponse {
+			ext.Error.Set(c.span, true)
+		}
+		c.span.Finish()
+	})
+}
+
+type redirectHop struct {
+	hop      int
+	original string
+	status   int
+}
+
+// tagRedirect records the position of the request within a chain of
+// redirects on its client span.
+func tagRedirect(ctx context.Context, span opentracing.Span) {
+	if hop, ok := ctx.Value(redirectHopKey{}).(redirectHop); ok {
+		span.SetTag(redirectHopTag, hop.hop)
+		span.SetTag(redirectOriginalURLTag, hop.original)
+		span.SetTag(redirectStatusTag, hop.status)
+	}
+}
+
+// TraceRedirects returns a copy of the client that records each call, along
+// with every redirect it follows, as a logical span with the given operation
+// name. Each hop is recorded by the Transport of the client as a child of the
+// logical span and every redirect is tagged with its hop number, the original
+// URL without its query, and the status of the response that caused it. The
+// Transport of the client should therefore include one created by
+// NewTransport. The CheckRedirect policy of the client, or the default policy
+// of following up to 10 redirects, is preserved.
+func TraceRedirects(client *http.Client, operation string) *http.Client {
+	var traced = *client
+	var transport = client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	traced.Transport = &redirectTransport{wrapped: transport, operation: operation}
+	var check = client.CheckRedirect
+	traced.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		var err error
+		if check != nil {
+			err = check(req, via)
+		} else if len(via) >= maxRedirects {
+			err = errors.New("stopped after 10 redirects")
+		}
+		if err != nil {
+			if chain := previousChain(req); chain != nil {
+				chain.finish(req.Response, err)
+			}
+		}
+		return err
+	}
+	return &traced
+}
+
+// previousChain returns the logical request of the hop that caused the
+// redirect request, if any.
+func previousChain(r *http.Request) *redirectChain {
+	if r.Response == nil || r.Response.Request == nil {
+		return nil
+	}
+	var chain, _ = r.Response.Request.Context().Value(redirectChainKey{}).(*redirectChain)
+	return chain
+}
+
+// redirectTransport starts the logical request on the first hop of a call and
+// sends every following hop within it. The logical request is finished by the
+// hop whose response is not followed or by the CheckRedirect policy refusing
+// to follow it.
+type redirectTransport struct {
+	wrapped   http.RoundTripper
+	operation string
+}
+
+func (t *redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var ctx context.Context
+	var chain = previousChain(r)
+	if chain == nil {
+		// The hops are not attempts of a retried call so the span is not
+		// started with StartLogicalRequest.
+		var span, ok = startLogicalSpan(r.Context(), t.operation)
+		ctx = r.Context()
+		if ok {
+			ctx = ContextWithSpan(ctx, span)
+		}
+		chain = &redirectChain{span: span, original: redactedURL(r.URL)}
+		ctx = context.WithValue(ctx, redirectChainKey{}, chain)
+		chain.ctx = ctx
+	} else {
+		chain.hops = chain.hops + 1
+		ctx = context.WithValue(chain.ctx, redirectHopKey{}, redirectHop{
+			hop:      chain.hops,
+			original: chain.original,
+			status:   r.Response.StatusCode,
+		})
+	}
+	var req = r.WithContext(ctx)
+	var resp, err = t.wrapped.RoundTrip(req)
+	if resp != nil {
+		// The next hop finds the chain through the request of this response,
+		// which the wrapped round tripper may have set to a request with a
+		// different context.
+		resp.Request = req
+	}
+	if err != nil || !followsRedirect(req, resp) {
+		chain.finish(resp, err)
+	}
+	return resp, err
+}
+
+// followsRedirect reports whether the http.Client will consider following the
+// response, subject to its CheckRedirect policy.
+func followsRedirect(r *http.Request, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther:
+	case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		if !replayable(r) {
+			return false
+		}
+	default:
+		return false
+	}
+	var location = resp.Header.Get("Location")
+	if location == "" {
+		return false
+	}
+	var _, err = r.URL.Parse(location)
+	return err == nil
+}
+
+// redactedURL returns the URL without any credentials, query, or fragment.
+func redactedURL(u *url.URL) string {
+	var redacted = *u
+	redacted.User = nil
+	redacted.RawQuery = ""
+	redacted.ForceQuery = false
+	redacted.Fragment = ""
+	redacted.RawFragment = ""
+	return redacted.String()
+}
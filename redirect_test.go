@@ -0,0 +1,190 @@
+package httptrace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func redirectServer() *httptest.Server {
+	var mux = http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/middle?token=secret", http.StatusFound)
+	})
+	mux.HandleFunc("/middle", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/end", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/end", func(w http.ResponseWriter, r *http.Request) {})
+	return httptest.NewServer(mux)
+}
+
+func TestTraceRedirects(t *testing.T) {
+	var server = redirectServer()
+	defer server.Close()
+
+	var recorder = NewRecorder()
+	var tracer, _ = NewTracer(nil, "client", "127.0.0.1:8080", TracerOptionReporter(recorder))
+	var parent = tracer.StartSpan("parent")
+	var client = TraceRedirects(&http.Client{
+		Transport: NewTransport(TransportOptionSpanName("TESTSPAN"))(http.DefaultTransport),
+	}, "GetWidget")
+	var r, _ = http.NewRequest(http.MethodGet, server.URL+"/start?token=secret", nil)
+	var resp, err = client.Do(r.WithContext(ContextWithSpan(context.Background(), parent)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	parent.Finish()
+
+	var logical = recorder.SpansNamed("GetWidget")
+	if len(logical) != 1 {
+		t.Fatalf("expected 1 logical span but got %d", len(logical))
+	}
+	recorder.AssertChildOf(t, logical[0], recorder.SpansNamed("parent")[0])
+	recorder.AssertTag(t, logical[0], redirectsTag, "2")
+	recorder.AssertTag(t, logical[0], "http.status_code", "200")
+	if _, ok := logical[0].Tags[attemptsTag]; ok {
+		t.Error("the redirects were recorded as attempts")
+	}
+	var hops = recorder.SpansNamed("TESTSPAN")
+	if len(hops) != 3 {
+		t.Fatalf("expected 3 hop spans but got %d", len(hops))
+	}
+	for _, hop := range hops {
+		recorder.AssertChildOf(t, hop, logical[0])
+		if _, ok := hop.Tags[attemptTag]; ok {
+			t.Errorf("hop was tagged as attempt %s", hop.Tags[attemptTag])
+		}
+	}
+	if _, ok := hops[0].Tags[redirectHopTag]; ok {
+		t.Error("the original request was tagged as a redirect")
+	}
+	recorder.AssertTag(t, hops[1], redirectHopTag, "1")
+	recorder.AssertTag(t, hops[1], redirectStatusTag, "302")
+	recorder.AssertTag(t, hops[1], redirectOriginalURLTag, server.URL+"/start")
+	recorder.AssertTag(t, hops[2], redirectHopTag, "2")
+	recorder.AssertTag(t, hops[2], redirectStatusTag, "307")
+	recorder.AssertTag(t, hops[2], "http.url", "/end")
+}
+
+func TestTraceRedirectsCheckRedirect(t *testing.T) {
+	var server = redirectServer()
+	defer server.Close()
+
+	var recorder = NewRecorder()
+	var tracer, _ = NewTracer(nil, "client", "127.0.0.1:8080", TracerOptionReporter(recorder))
+	var parent = tracer.StartSpan("parent")
+	var stop = errors.New("stop")
+	var client = TraceRedirects(&http.Client{
+		Transport: NewTransport(TransportOptionSpanName("TESTSPAN"))(http.DefaultTransport),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > 1 {
+				return stop
+			}
+			return nil
+		},
+	}, "GetWidget")
+	var r, _ = http.NewRequest(http.MethodGet, server.URL+"/start", nil)
+	var _, err = client.Do(r.WithContext(ContextWithSpan(context.Background(), parent)))
+	if !errors.Is(err, stop) {
+		t.Fatalf("expected the error of the redirect policy but got %v", err)
+	}
+	parent.Finish()
+
+	var logical = recorder.SpansNamed("GetWidget")
+	if len(logical) != 1 {
+		t.Fatalf("expected the logical span to be finished but got %d", len(logical))
+	}
+	recorder.AssertTag(t, logical[0], redirectsTag, "1")
+	recorder.AssertTag(t, logical[0], "http.status_code", "307")
+	recorder.AssertTag(t, logical[0], "error", "true")
+	if hops := recorder.SpansNamed("TESTSPAN"); len(hops) != 2 {
+		t.Errorf("expected 2 hop spans but got %d", len(hops))
+	}
+}
+
+func TestTraceRedirectsUnreplayableBody(t *testing.T) {
+	var server = redirectServer()
+	defer server.Close()
+
+	var recorder = NewRecorder()
+	var tracer, _ = NewTracer(nil, "client", "127.0.0.1:8080", TracerOptionReporter(recorder))
+	var parent = tracer.StartSpan("parent")
+	var client = TraceRedirects(&http.Client{
+		Transport: NewTransport(TransportOptionSpanName("TESTSPAN"))(http.DefaultTransport),
+	}, "PostWidget")
+	var r, _ = http.NewRequest(http.MethodPost, server.URL+"/middle", strings.NewReader("body"))
+	r.GetBody = nil
+	var resp, err = client.Do(r.WithContext(ContextWithSpan(context.Background(), parent)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	parent.Finish()
+
+	var logical = recorder.SpansNamed("PostWidget")
+	if len(logical) != 1 {
+		t.Fatalf("expected the logical span to be finished but got %d", len(logical))
+	}
+	recorder.AssertTag(t, logical[0], redirectsTag, "0")
+	recorder.AssertTag(t, logical[0], "http.status_code", "307")
+}
+
+func TestTraceRedirectsWithRetryPolicy(t *testing.T) {
+	var server = redirectServer()
+	defer server.Close()
+
+	var recorder = NewRecorder()
+	var tracer, _ = NewTracer(nil, "client", "127.0.0.1:8080", TracerOptionReporter(recorder))
+	var parent = tracer.StartSpan("parent")
+	var client = TraceRedirects(&http.Client{
+		Transport: NewTransport(
+			TransportOptionSpanName("TESTSPAN"),
+			TransportOptionRetryPolicy(retryUnavailable),
+		)(http.DefaultTransport),
+	}, "GetWidget")
+	var r, _ = http.NewRequest(http.MethodGet, server.URL+"/start", nil)
+	var resp, err = client.Do(r.WithContext(ContextWithSpan(context.Background(), parent)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	parent.Finish()
+
+	var logical = recorder.SpansNamed("GetWidget")
+	if len(logical) != 1 {
+		t.Fatalf("expected 1 logical span but got %d", len(logical))
+	}
+	recorder.AssertTag(t, logical[0], redirectsTag, "2")
+	// Each hop is a retried call with a logical span and a single attempt.
+	var spans = recorder.SpansNamed("TESTSPAN")
+	if len(spans) != 6 {
+		t.Fatalf("expected 3 attempt and 3 retry spans but got %d", len(spans))
+	}
+	var hops, attempts []string
+	for _, span := range spans {
+		if _, ok := span.Tags[attemptsTag]; ok {
+			recorder.AssertChildOf(t, span, logical[0])
+			hops = append(hops, span.ID.String())
+		}
+	}
+	for _, span := range spans {
+		if _, ok := span.Tags[attemptTag]; ok {
+			if span.ParentID == nil {
+				t.Fatal("attempt span has no parent")
+			}
+			attempts = append(attempts, span.ParentID.String())
+		}
+	}
+	if len(hops) != 3 || len(attempts) != 3 {
+		t.Fatalf("expected 3 hops with 3 attempts but got %v and %v", hops, attempts)
+	}
+	for i := range hops {
+		if hops[i] != attempts[i] {
+			t.Errorf("attempt %d is not a child of its hop", i+1)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package httptrace
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+const (
+	upgradeTag      = "http.upgrade"
+	bytesReadTag    = "net.bytes_read"
+	bytesWrittenTag = "net.bytes_written"
+	closeReasonTag  = "close.reason"
+	responseSizeTag = "http.response_size"
+	flushCountTag   = "http.flush_count"
+	firstWriteEvent = "first-write"
+	localClose      = "local close"
+	remoteClose     = "remote close"
+)
+
+// responseWriter wraps the http.ResponseWriter given to the Middleware in
+// order to observe the response. It implements the optional http.Flusher,
+// http.Hijacker, http.Pusher, and io.ReaderFrom interfaces but must only be
+// given to handlers through wrapResponseWriter, which exposes just those that
+// the wrapped writer implements.
+type responseWriter struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+	written  int64
+	flushes  int
+	// onFirstWrite, if set, is called before the first write of the body.
+	onFirstWrite func()
+	// onHijack, if set, is given the connection taken over by the handler and
+	// returns the connection that the handler uses in its place.
+	onHijack func(net.Conn) net.Conn
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.onFirstWrite != nil {
+		w.onFirstWrite()
+		w.onFirstWrite = nil
+	}
+	var n, err = w.ResponseWriter.Write(b)
+	w.written = w.written + int64(n)
+	return n, err
+}
+
+// ReadFrom belongs to the io.ReaderFrom interface.
+func (w *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.onFirstWrite != nil {
+		w.onFirstWrite()
+		w.onFirstWrite = nil
+	}
+	var n, err = w.ResponseWriter.(io.ReaderFrom).ReadFrom(src)
+	w.written = w.written + n
+	return n, err
+}
+
+// Flush belongs to the http.Flusher interface.
+func (w *responseWriter) Flush() {
+	w.flushes = w.flushes + 1
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+// Push belongs to the http.Pusher interface.
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+// Hijack belongs to the http.Hijacker interface.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	var conn, rw, err = w.ResponseWriter.(http.Hijacker).Hijack()
+	if err != nil {
+		return conn, rw, err
+	}
+	w.hijacked = true
+	if w.onHijack == nil {
+		return conn, rw, nil
+	}
+	// The connection is replaced so the buffered reader given to the handler
+	// must read through the replacement. Any data buffered before the
+	// connection was taken over is read first.
+	var buffered []byte
+	if n := rw.Reader.Buffered(); n > 0 {
+		var data, _ = rw.Reader.Peek(n)
+		buffered = append(buffered, data...)
+	}
+	conn = w.onHijack(conn)
+	var r io.Reader = conn
+	if len(buffered) > 0 {
+		r = io.MultiReader(bytes.NewReader(buffered), conn)
+	}
+	return conn, bufio.NewReadWriter(bufio.NewReader(r), bufio.NewWriter(conn)), nil
+}
+
+// Unwrap allows http.ResponseController to reach the wrapped writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// observedWriter is the part of the responseWriter that every handler sees.
+type observedWriter interface {
+	http.ResponseWriter
+	Unwrap() http.ResponseWriter
+}
+
+// wrapResponseWriter returns the responseWriter as an http.ResponseWriter that
+// implements each of the optional http.Flusher, http.Hijacker, http.Pusher,
+// and io.ReaderFrom interfaces only if the wrapped writer does. Handlers that
+// detect streaming or push support with a type assertion therefore see the
+// same capabilities as without the Middleware.
+func wrapResponseWriter(w *responseWriter) http.ResponseWriter {
+	var index int
+	if _, ok := w.ResponseWriter.(http.Flusher); ok {
+		index = index | 1
+	}
+	if _, ok := w.ResponseWriter.(http.Hijacker); ok {
+		index = index | 2
+	}
+	if _, ok := w.ResponseWriter.(http.Pusher); ok {
+		index = index | 4
+	}
+	if _, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		index = index | 8
+	}
+	switch index {
+	case 1:
+		return struct {
+			observedWriter
+			http.Flusher
+		}{w, w}
+	case 2:
+		return struct {
+			observedWriter
+			http.Hijacker
+		}{w, w}
+	case 3:
+		return struct {
+			observedWriter
+			http.Flusher
+			http.Hijacker
+		}{w, w, w}
+	case 4:
+		return struct {
+			observedWriter
+			http.Pusher
+		}{w, w}
+	case 5:
+		return struct {
+			observedWriter
+			http.Flusher
+			http.Pusher
+		}{w, w, w}
+	case 6:
+		return struct {
+			observedWriter
+			http.Hijacker
+			http.Pusher
+		}{w, w, w}
+	case 7:
+		return struct {
+			observedWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{w, w, w, w}
+	case 8:
+		return struct {
+			observedWriter
+			io.ReaderFrom
+		}{w, w}
+	case 9:
+		return struct {
+			observedWriter
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w}
+	case 10:
+		return struct {
+			observedWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w}
+	case 11:
+		return struct {
+			observedWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w, w}
+	case 12:
+		return struct {
+			observedWriter
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w}
+	case 13:
+		return struct {
+			observedWriter
+			http.Flusher
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w}
+	case 14:
+		return struct {
+			observedWriter
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w}
+	case 15:
+		return struct {
+			observedWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w, w}
+	}
+	return struct {
+		observedWriter
+	}{w}
+}
+
+// tracedConn records the lifetime of an upgraded connection as a span. The
+// span is finished when the connection is closed.
+type tracedConn struct {
+	// read and written are accessed atomically and must remain the first
+	// fields to guarantee 64-bit alignment.
+	read    uint64
+	written uint64
+	net.Conn
+	span   opentracing.Span
+	lock   sync.Mutex
+	reason string
+	failed bool
+	once   sync.Once
+}
+
+// newTracedConn starts a span for the upgraded connection as a child of the
+// server span of the request.
+func newTracedConn(conn net.Conn, parent opentracing.Span, r *http.Request) *tracedConn {
+	var span = parent.Tracer().StartSpan("UpgradedConnection", opentracing.ChildOf(parent.Context()))
+	ext.SpanKindRPCServer.Set(span)
+	span.SetTag(upgradeTag, r.Header.Get("Upgrade"))
+	return &tracedConn{Conn: conn, span: span}
+}
+
+// fail records the first reason for the connection ending.
+func (c *tracedConn) fail(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.reason != "" {
+		return
+	}
+	if err == io.EOF {
+		c.reason = remoteClose
+		return
+	}
+	if errors.Is(err, net.ErrClosed) {
+		c.reason = localClose
+		return
+	}
+	c.reason = err.Error()
+	c.failed = true
+}
+
+func (c *tracedConn) Read(b []byte) (int, error) {
+	var n, err = c.Conn.Read(b)
+	atomic.AddUint64(&c.read, uint64(n))
+	if err != nil {
+		c.fail(err)
+	}
+	return n, err
+}
+
+func (c *tracedConn) Write(b []byte) (int, error) {
+	var n, err = c.Conn.Write(b)
+	atomic.AddUint64(&c.written, uint64(n))
+	if err != nil {
+		c.fail(err)
+	}
+	return n, err
+}
+
+func (c *tracedConn) Close() error {
+	// The reason is recorded before closing so that a Read blocked on the
+	// connection, which fails once it is closed, is not taken as the cause.
+	c.lock.Lock()
+	if c.reason == "" {
+		c.reason = localClose
+	}
+	c.lock.Unlock()
+	var err = c.Conn.Close()
+	c.once.Do(func() {
+		c.lock.Lock()
+		c.span.SetTag(closeReasonTag, c.reason)
+		if c.failed {
+			ext.Error.Set(c.span, true)
+		}
+		c.lock.Unlock()
+		c.span.SetTag(bytesReadTag, atomic.LoadUint64(&c.read))
+		c.span.SetTag(bytesWrittenTag, atomic.LoadUint64(&c.written))
+		c.span.Finish()
+	})
+	return err
+}
@@ -0,0 +1,282 @@
+package httptrace
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestResponseWriterPreservesInterfaces(t *testing.T) {
+	var recorder = NewRecorder()
+	var flushed bool
+	var handler = NewMiddleware(
+		MiddlewareOptionServiceName("testservice"),
+		MiddlewareOptionTracerOptions(TracerOptionReporter(recorder)),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Hijacker); ok {
+			t.Error("writer is a Hijacker but the wrapped writer is not")
+		}
+		if _, ok := w.(http.Pusher); ok {
+			t.Error("writer is a Pusher but the wrapped writer is not")
+		}
+		if _, ok := w.(io.ReaderFrom); ok {
+			t.Error("writer is a ReaderFrom but the wrapped writer is not")
+		}
+		w.WriteHeader(http.StatusTeapot)
+		w.(http.Flusher).Flush()
+		flushed = true
+	}))
+	var w = httptest.NewRecorder()
+	var r, _ = http.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(w, r)
+
+	if !flushed || !w.Flushed {
+		t.Error("flush was not passed to the wrapped writer")
+	}
+	if w.Code != http.StatusTeapot {
+		t.Errorf("expected status 418 but got %d", w.Code)
+	}
+	recorder.AssertTag(t, recorder.SpansNamed("testservice")[0], "http.status_code", "418")
+}
+
+// fixtureFullWriter implements every optional interface of a ResponseWriter.
+type fixtureFullWriter struct {
+	*httptest.ResponseRecorder
+	pushed   string
+	readFrom int64
+}
+
+func (w *fixtureFullWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, http.ErrNotSupported
+}
+
+func (w *fixtureFullWriter) Push(target string, opts *http.PushOptions) error {
+	w.pushed = target
+	return nil
+}
+
+func (w *fixtureFullWriter) ReadFrom(src io.Reader) (int64, error) {
+	var n, err = io.Copy(w.ResponseRecorder, src)
+	w.readFrom = w.readFrom + n
+	return n, err
+}
+
+func TestResponseWriterExposesWrappedInterfaces(t *testing.T) {
+	var tests = []struct {
+		name     string
+		writer   http.ResponseWriter
+		flusher  bool
+		hijacker bool
+		pusher   bool
+		reader   bool
+	}{
+		{"none", struct{ http.ResponseWriter }{httptest.NewRecorder()}, false, false, false, false},
+		{"recorder", httptest.NewRecorder(), true, false, false, false},
+		{"all", &fixtureFullWriter{ResponseRecorder: httptest.NewRecorder()}, true, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w = wrapResponseWriter(&responseWriter{ResponseWriter: tt.writer})
+			if _, ok := w.(http.Flusher); ok != tt.flusher {
+				t.Errorf("expected Flusher %t but got %t", tt.flusher, ok)
+			}
+			if _, ok := w.(http.Hijacker); ok != tt.hijacker {
+				t.Errorf("expected Hijacker %t but got %t", tt.hijacker, ok)
+			}
+			if _, ok := w.(http.Pusher); ok != tt.pusher {
+				t.Errorf("expected Pusher %t but got %t", tt.pusher, ok)
+			}
+			if _, ok := w.(io.ReaderFrom); ok != tt.reader {
+				t.Errorf("expected ReaderFrom %t but got %t", tt.reader, ok)
+			}
+			if u, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok || u.Unwrap() != tt.writer {
+				t.Error("writer does not unwrap to the wrapped writer")
+			}
+		})
+	}
+}
+
+func TestResponseWriterReadFrom(t *testing.T) {
+	var recorder = NewRecorder()
+	var full = &fixtureFullWriter{ResponseRecorder: httptest.NewRecorder()}
+	var handler = NewMiddleware(
+		MiddlewareOptionServiceName("testservice"),
+		MiddlewareOptionTracerOptions(TracerOptionReporter(recorder)),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.(io.ReaderFrom).ReadFrom(strings.NewReader("streamed body"))
+		_ = w.(http.Pusher).Push("/style.css", nil)
+	}))
+	var r, _ = http.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(full, r)
+
+	if full.readFrom != 13 || full.Body.String() != "streamed body" {
+		t.Errorf("ReadFrom was not passed to the wrapped writer")
+	}
+	if full.pushed != "/style.css" {
+		t.Errorf("Push was not passed to the wrapped writer")
+	}
+	var span = recorder.SpansNamed("testservice")[0]
+	recorder.AssertTag(t, span, "http.status_code", "200")
+	recorder.AssertTag(t, span, "http.response_size", "13")
+	if len(span.Annotations) != 1 || span.Annotations[0].Value != "first-write" {
+		t.Errorf("expected a first-write annotation but got %v", span.Annotations)
+	}
+}
+
+const upgradeResponse = "HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\nhello"
+
+func TestMiddlewareTracesUpgrades(t *testing.T) {
+	var recorder = NewRecorder()
+	var done = make(chan struct{})
+	var handler = NewMiddleware(
+		MiddlewareOptionServiceName("testservice"),
+		MiddlewareOptionTracerOptions(TracerOptionReporter(recorder)),
+		MiddlewareOptionTraceUpgrades(true),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var conn, rw, err = w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			close(done)
+			return
+		}
+		go func() {
+			defer close(done)
+			defer conn.Close()
+			_, _ = rw.WriteString(upgradeResponse)
+			_ = rw.Flush()
+			_, _ = io.Copy(io.Discard, rw)
+		}()
+	}))
+	var server = httptest.NewServer(handler)
+	defer server.Close()
+
+	var conn, err = net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n"))
+	var reader = bufio.NewReader(conn)
+	var resp, er = http.ReadResponse(reader, nil)
+	if er != nil {
+		t.Fatal(er)
+	}
+	var body = make([]byte, 5)
+	_, _ = io.ReadFull(reader, body)
+	if resp.StatusCode != http.StatusSwitchingProtocols || string(body) != "hello" {
+		t.Errorf("unexpected upgrade response %d %s", resp.StatusCode, body)
+	}
+	_, _ = conn.Write([]byte("abc"))
+	_ = conn.Close()
+	<-done
+
+	var spans = recorder.SpansNamed("UpgradedConnection")
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 connection span but got %d", len(spans))
+	}
+	var serverSpan = recorder.SpansNamed("testservice")[0]
+	recorder.AssertChildOf(t, spans[0], serverSpan)
+	recorder.AssertTag(t, serverSpan, "http.upgrade", "test")
+	recorder.AssertTag(t, spans[0], "close.reason", "remote close")
+	recorder.AssertTag(t, spans[0], "net.bytes_read", "3")
+	recorder.AssertTag(t, spans[0], "net.bytes_written", strconv.Itoa(len(upgradeResponse)))
+}
+
+func TestMiddlewareStreamingAnnotations(t *testing.T) {
+	var recorder = NewRecorder()
+	var handler = NewMiddleware(
+		MiddlewareOptionServiceName("testservice"),
+		MiddlewareOptionTracerOptions(TracerOptionReporter(recorder)),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for x := 0; x < 3; x = x + 1 {
+			_, _ = w.Write([]byte("data: event\n\n"))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	var r, _ = http.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	var span = recorder.SpansNamed("testservice")[0]
+	recorder.AssertTag(t, span, "http.response_size", "39")
+	recorder.AssertTag(t, span, "http.flush_count", "3")
+	if len(span.Annotations) != 1 || span.Annotations[0].Value != "first-write" {
+		t.Fatalf("expected a single first-write annotation but got %v", span.Annotations)
+	}
+	if span.Annotations[0].Timestamp.Before(span.Timestamp) || span.Annotations[0].Timestamp.After(span.Timestamp.Add(span.Duration)) {
+		t.Error("first-write annotation is outside of the span")
+	}
+}
+
+func TestTracedConnLocalCloseWithBlockedRead(t *testing.T) {
+	var listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var client, er = net.Dial("tcp", listener.Addr().String())
+	if er != nil {
+		t.Fatal(er)
+	}
+	defer client.Close()
+	var server, e = listener.Accept()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var recorder = NewRecorder()
+	var tracer, _ = NewTracer(nil, "testservice", "127.0.0.1:8080", TracerOptionReporter(recorder))
+	var parent = tracer.StartSpan("testservice")
+	var r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Set("Upgrade", "test")
+	var conn = newTracedConn(server, parent, r)
+
+	var reading = make(chan struct{})
+	var done = make(chan error)
+	go func() {
+		close(reading)
+		var _, readErr = conn.Read(make([]byte, 1))
+		done <- readErr
+	}()
+	<-reading
+	_ = conn.Close()
+	if readErr := <-done; readErr == nil {
+		t.Fatal("expected the blocked read to fail")
+	}
+	parent.Finish()
+
+	var spans = recorder.SpansNamed("UpgradedConnection")
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 connection span but got %d", len(spans))
+	}
+	recorder.AssertTag(t, spans[0], "close.reason", "local close")
+	if _, ok := spans[0].Tags["error"]; ok {
+		t.Error("a local close was recorded as an error")
+	}
+}
+
+func TestTracedConnFailReason(t *testing.T) {
+	var tests = []struct {
+		name   string
+		err    error
+		reason string
+		failed bool
+	}{
+		{"eof", io.EOF, "remote close", false},
+		{"closed", &net.OpError{Op: "read", Err: net.ErrClosed}, "local close", false},
+		{"reset", errors.New("connection reset by peer"), "connection reset by peer", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conn = &tracedConn{}
+			conn.fail(tt.err)
+			if conn.reason != tt.reason || conn.failed != tt.failed {
+				t.Errorf("expected %q failed=%t but got %q failed=%t", tt.reason, tt.failed, conn.reason, conn.failed)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package httptrace
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+const (
+	attemptTag      = "http.attempt"
+	attemptsTag     = "http.attempts"
+	retryReasonTag  = "retry.reason"
+	retryBackoffTag = "retry.backoff_ms"
+
+	// maxDrainBytes limits how much of a discarded response body is read so
+	// that the connection can be reused. Larger bodies are closed unread.
+	maxDrainBytes = 64 << 10
+)
+
+type logicalRequestKey struct{}
+type retryKey struct{}
+
+// logicalRequest marks a context as belonging to a logical call that may be
+// sent as several requests.
+type logicalRequest struct {
+	// attempts is accessed atomically and must remain the first field to
+	// guarantee 64-bit alignment.
+	attempts int64
+	span     opentracing.Span
+}
+
+// attempt returns the number of the next request sent for the logical call.
+func (l *logicalRequest) attempt() int64 {
+	return atomic.AddInt64(&l.attempts, 1)
+}
+
+// logicalSpan tags the span of a logical call with the number of requests it
+// took when finished.
+type logicalSpan struct {
+	opentracing.Span
+	request *logicalRequest
+}
+
+func (s *logicalSpan) Finish() {
+	s.FinishWithOptions(opentracing.FinishOptions{})
+}
+
+func (s *logicalSpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	if attempts := atomic.LoadInt64(&s.request.attempts); attempts > 0 {
+		s.Span.SetTag(attemptsTag, attempts)
+	}
+	s.Span.FinishWithOptions(opts)
+}
+
+type retryInfo struct {
+	reason  string
+	backoff time.Duration
+}
+
+// StartLogicalRequest starts a span representing a single logical call, such
+// as one made through a retrying client, that may be sent as several requests.
+// Every request sent through the Transport with the returned context is
+// recorded as a child of the logical span and tagged with its attempt number.
+// The span is a child of the active span of the context or, without one, a
+// root span of the global tracer. The caller must finish the span. If there is
+// no tracer then the context is returned unchanged with a span that records
+// nothing.
+func StartLogicalRequest(ctx context.Context, operation string) (context.Context, opentracing.Span) {
+	var span, ok = startLogicalSpan(ctx, operation)
+	if !ok {
+		return ctx, span
+	}
+	var request = &logicalRequest{}
+	var logical = &logicalSpan{Span: span, request: request}
+	request.span = logical
+	ctx = context.WithValue(ctx, logicalRequestKey{}, request)
+	return ContextWithSpan(ctx, logical), logical
+}
+
+// startLogicalSpan starts a span as a child of the active span of the context
+// or, without one, as a root span of the global tracer. A span that records
+// nothing is returned, and false is reported, if there is no tracer.
+func startLogicalSpan(ctx context.Context, operation string) (opentracing.Span, bool) {
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		return parent.Tracer().StartSpan(operation, opentracing.ChildOf(parent.Context())), true
+	}
+	if tracer := GlobalTracer(); tracer != nil {
+		return tracer.StartSpan(operation), true
+	}
+	return opentracing.NoopTracer{}.StartSpan(operation), false
+}
+
+// logicalRequestFromContext returns the logical call of the context if the
+// active span of the context is the span of that call.
+func logicalRequestFromContext(ctx context.Context) *logicalRequest {
+	var request, ok = ctx.Value(logicalRequestKey{}).(*logicalRequest)
+	if !ok || opentracing.SpanFromContext(ctx) != request.span {
+		return nil
+	}
+	return request
+}
+
+// ContextWithRetry marks a request as a retry of a previous attempt. The span
+// recorded by the Transport for the request is tagged with the reason for the
+// retry and the time waited before sending it. Retrying clients call this for
+// every attempt after the first, usually with a context from
+// StartLogicalRequest.
+func ContextWithRetry(ctx context.Context, reason string, backoff time.Duration) context.Context {
+	return context.WithValue(ctx, retryKey{}, retryInfo{reason: reason, backoff: backoff})
+}
+
+// tagAttempt records the attempt number and retry details of the request on
+// its client span.
+func tagAttempt(ctx context.Context, span opentracing.Span) {
+	if request := logicalRequestFromContext(ctx); request != nil {
+		span.SetTag(attemptTag, request.attempt())
+	}
+	if info, ok := ctx.Value(retryKey{}).(retryInfo); ok {
+		span.SetTag(retryReasonTag, info.reason)
+		span.SetTag(retryBackoffTag, info.backoff.Milliseconds())
+	}
+}
+
+// RetryPolicy decides whether a request is sent again after the given attempt,
+// numbered from 1, returned a response or an error. The reason is recorded on
+// the span of the next attempt, which is sent after waiting for the backoff.
+// The body of a response that is retried is closed by the Transport.
+type RetryPolicy func(attempt int, resp *http.Response, err error) (retry bool, reason string, backoff time.Duration)
+
+// TransportOptionRetryPolicy makes the Transport retry requests according to
+// the policy. Each request is recorded as a logical span, named after the
+// client spans, with a client span for every attempt. Requests with a body
+// are only retried if the body can be replayed using GetBody. Waiting for a
+// retry ends early with the error of the context if it is cancelled.
+func TransportOptionRetryPolicy(policy RetryPolicy) TransportOption {
+	return func(t *Transport) *Transport {
+		t.retryPolicy = policy
+		return t
+	}
+}
+
+// retry sends the request until the retry policy is satisfied.
+func (c *Transport) retry(r *http.Request) (resp *http.Response, err error) {
+	var ctx = r.Context()
+	if c.spanFilter(r) {
+		if opentracing.SpanFromContext(ctx) == nil && c.parentSpan != nil {
+			if parent := c.parentSpan(r); parent != nil {
+				ctx = opentracing.ContextWithSpan(ctx, parent)
+			}
+		}
+		var span opentracing.Span
+		ctx, span = StartLogicalRequest(ctx, c.spanName)
+		defer span.Finish()
+		defer func() {
+			if resp != nil {
+				ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
+			}
+			if err != nil {
+				ext.Error.Set(span, true)
+			}
+		}()
+	}
+	var attemptCtx = ctx
+	for attempt := 1; ; attempt = attempt + 1 {
+		var req = r.WithContext(attemptCtx)
+		if attempt > 1 && r.GetBody != nil {
+			var body, er = r.GetBody()
+			if er != nil {
+				return nil, er
+			}
+			req.Body = body
+		}
+		resp, err = c.roundTrip(req)
+		var again, reason, backoff = c.retryPolicy(attempt, resp, err)
+		if !again || !replayable(r) {
+			return resp, err
+		}
+		if resp != nil && resp.Body != nil {
+			_, _ = io.CopyN(io.Discard, resp.Body, maxDrainBytes)
+			_ = resp.Body.Close()
+		}
+		var timer = time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		attemptCtx = ContextWithRetry(ctx, reason, backoff)
+	}
+}
+
+// replayable reports whether the body of the request can be sent again.
+func replayable(r *http.Request) bool {
+	return r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+}
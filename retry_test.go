@@ -0,0 +1,220 @@
+package httptrace
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fixtureAttempts struct {
+	Responses []*http.Response
+	Requests  []*http.Request
+}
+
+func (c *fixtureAttempts) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.Requests = append(c.Requests, r)
+	var resp = c.Responses[0]
+	if len(c.Responses) > 1 {
+		c.Responses = c.Responses[1:]
+	}
+	return resp, nil
+}
+
+func fixtureStatus(code int) *http.Response {
+	return &http.Response{StatusCode: code, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}
+}
+
+func retryUnavailable(attempt int, resp *http.Response, err error) (bool, string, time.Duration) {
+	if attempt < 3 && resp != nil && resp.StatusCode == http.StatusServiceUnavailable {
+		return true, "unavailable", time.Millisecond
+	}
+	return false, "", 0
+}
+
+func TestTransportRetryPolicy(t *testing.T) {
+	var recorder = NewRecorder()
+	var tracer, _ = NewTracer(nil, "client", "127.0.0.1:8080", TracerOptionReporter(recorder))
+	var parent = tracer.StartSpan("parent")
+	var fixture = &fixtureAttempts{Responses: []*http.Response{
+		fixtureStatus(http.StatusServiceUnavailable),
+		fixtureStatus(http.StatusOK),
+	}}
+	var wrapped = NewTransport(
+		TransportOptionSpanName("TESTSPAN"),
+		TransportOptionRetryPolicy(retryUnavailable),
+	)(fixture)
+
+	var r, _ = http.NewRequest(http.MethodGet, "http://localhost/", nil)
+	r = r.WithContext(ContextWithSpan(context.Background(), parent))
+	var resp, err = wrapped.RoundTrip(r)
+	parent.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the response of the last attempt but got %d", resp.StatusCode)
+	}
+	if len(fixture.Requests) != 2 {
+		t.Fatalf("expected 2 attempts but got %d", len(fixture.Requests))
+	}
+
+	var spans = recorder.SpansNamed("TESTSPAN")
+	if len(spans) != 3 {
+		t.Fatalf("expected 2 attempt spans and a logical span but got %d", len(spans))
+	}
+	var first, second, logical = spans[0], spans[1], spans[2]
+	recorder.AssertChildOf(t, logical, recorder.SpansNamed("parent")[0])
+	recorder.AssertTag(t, logical, attemptsTag, "2")
+	recorder.AssertTag(t, logical, "http.status_code", "200")
+	recorder.AssertChildOf(t, first, logical)
+	recorder.AssertChildOf(t, second, logical)
+	recorder.AssertTag(t, first, attemptTag, "1")
+	recorder.AssertTag(t, first, "http.status_code", "503")
+	recorder.AssertTag(t, second, attemptTag, "2")
+	recorder.AssertTag(t, second, retryReasonTag, "unavailable")
+	recorder.AssertTag(t, second, retryBackoffTag, "1")
+	if _, ok := first.Tags[retryReasonTag]; ok {
+		t.Error("first attempt was tagged as a retry")
+	}
+}
+
+func TestTransportRetryRequiresReplayableBody(t *testing.T) {
+	var fixture = &fixtureAttempts{Responses: []*http.Response{
+		fixtureStatus(http.StatusServiceUnavailable),
+		fixtureStatus(http.StatusOK),
+	}}
+	var wrapped = NewTransport(TransportOptionRetryPolicy(retryUnavailable))(fixture)
+
+	var r, _ = http.NewRequest(http.MethodPost, "http://localhost/", io.NopCloser(strings.NewReader("body")))
+	var resp, err = wrapped.RoundTrip(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || len(fixture.Requests) != 1 {
+		t.Errorf("retried a request with a body that cannot be replayed")
+	}
+
+	fixture = &fixtureAttempts{Responses: []*http.Response{
+		fixtureStatus(http.StatusServiceUnavailable),
+		fixtureStatus(http.StatusOK),
+	}}
+	wrapped = NewTransport(TransportOptionRetryPolicy(retryUnavailable))(fixture)
+	r, _ = http.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader("body"))
+	resp, err = wrapped.RoundTrip(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(fixture.Requests) != 2 {
+		t.Fatalf("did not retry a request with a replayable body")
+	}
+	var body, _ = io.ReadAll(fixture.Requests[1].Body)
+	if string(body) != "body" {
+		t.Errorf("retry sent body %q", body)
+	}
+}
+
+func TestTransportRetryCancelled(t *testing.T) {
+	var fixture = &fixtureAttempts{Responses: []*http.Response{fixtureStatus(http.StatusServiceUnavailable)}}
+	var wrapped = NewTransport(TransportOptionRetryPolicy(func(int, *http.Response, error) (bool, string, time.Duration) {
+		return true, "always", time.Hour
+	}))(fixture)
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	var r, _ = http.NewRequest(http.MethodGet, "http://localhost/", nil)
+	var _, err = wrapped.RoundTrip(r.WithContext(ctx))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context error but got %v", err)
+	}
+}
+
+// fixtureEndlessBody is a response body that never ends.
+type fixtureEndlessBody struct {
+	read   int64
+	closed bool
+}
+
+func (b *fixtureEndlessBody) Read(p []byte) (int, error) {
+	b.read = b.read + int64(len(p))
+	return len(p), nil
+}
+
+func (b *fixtureEndlessBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestTransportRetryDrainLimit(t *testing.T) {
+	var body = &fixtureEndlessBody{}
+	var fixture = &fixtureAttempts{Responses: []*http.Response{
+		{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}, Body: body},
+		fixtureStatus(http.StatusOK),
+	}}
+	var wrapped = NewTransport(TransportOptionRetryPolicy(retryUnavailable))(fixture)
+
+	var r, _ = http.NewRequest(http.MethodGet, "http://localhost/", nil)
+	var resp, err = wrapped.RoundTrip(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the response of the last attempt but got %d", resp.StatusCode)
+	}
+	if body.read > maxDrainBytes {
+		t.Errorf("read %d bytes of a discarded body", body.read)
+	}
+	if !body.closed {
+		t.Error("discarded body was not closed")
+	}
+}
+
+func TestStartLogicalRequest(t *testing.T) {
+	var recorder = NewRecorder()
+	var tracer, _ = NewTracer(nil, "client", "127.0.0.1:8080", TracerOptionReporter(recorder))
+	var parent = tracer.StartSpan("parent")
+	var wrapped = NewTransport(TransportOptionSpanName("TESTSPAN"))(&fixtureTransport{Response: fixtureStatus(http.StatusOK)})
+
+	// A retrying client above the Transport marks each attempt.
+	var ctx, logical = StartLogicalRequest(ContextWithSpan(context.Background(), parent), "GetWidget")
+	for attempt := 1; attempt <= 3; attempt = attempt + 1 {
+		var attemptCtx = ctx
+		if attempt > 1 {
+			attemptCtx = ContextWithRetry(ctx, "timeout", 20*time.Millisecond)
+		}
+		var r, _ = http.NewRequest(http.MethodGet, "http://localhost/", nil)
+		if _, err := wrapped.RoundTrip(r.WithContext(attemptCtx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logical.Finish()
+	parent.Finish()
+
+	var logicalSpans = recorder.SpansNamed("GetWidget")
+	if len(logicalSpans) != 1 {
+		t.Fatalf("expected 1 logical span but got %d", len(logicalSpans))
+	}
+	recorder.AssertChildOf(t, logicalSpans[0], recorder.SpansNamed("parent")[0])
+	recorder.AssertTag(t, logicalSpans[0], attemptsTag, "3")
+	var attempts = recorder.SpansNamed("TESTSPAN")
+	if len(attempts) != 3 {
+		t.Fatalf("expected 3 attempt spans but got %d", len(attempts))
+	}
+	for i, attempt := range attempts {
+		recorder.AssertChildOf(t, attempt, logicalSpans[0])
+		recorder.AssertTag(t, attempt, attemptTag, string(rune('1'+i)))
+	}
+	recorder.AssertTag(t, attempts[2], retryReasonTag, "timeout")
+	recorder.AssertTag(t, attempts[2], retryBackoffTag, "20")
+}
+
+func TestStartLogicalRequestWithoutTracer(t *testing.T) {
+	var ctx, span = StartLogicalRequest(context.Background(), "GetWidget")
+	if ctx != context.Background() {
+		t.Error("modified the context without a tracer")
+	}
+	span.Finish()
+}
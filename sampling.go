@@ -0,0 +1,158 @@
+package httptrace
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+// TailSamplingRule decides whether the spans of a complete local trace are
+// kept.
+type TailSamplingRule func(spans []model.SpanModel) bool
+
+// TailSampleErrors keeps traces that contain a span tagged as an error.
+func TailSampleErrors() TailSamplingRule {
+	return func(spans []model.SpanModel) bool {
+		for _, s := range spans {
+			if s.Tags[string(ext.Error)] == "true" {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// TailSampleSlowerThan keeps traces that contain a span lasting at least the
+// given duration.
+func TailSampleSlowerThan(threshold time.Duration) TailSamplingRule {
+	return func(spans []model.SpanModel) bool {
+		for _, s := range spans {
+			if s.Duration >= threshold {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// TailSampleStatusClass keeps traces that contain a span with an HTTP status
+// code in one of the given classes. A class is the first digit of the code, so
+// 5 matches every 5xx status.
+func TailSampleStatusClass(classes ...int) TailSamplingRule {
+	return func(spans []model.SpanModel) bool {
+		for _, s := range spans {
+			var code, err = strconv.Atoi(s.Tags[string(ext.HTTPStatusCode)])
+			if err != nil {
+				continue
+			}
+			for _, class := range classes {
+				if code/100 == class {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// TracerOptionTailSampling holds the spans of each trace in memory until every
+// span of that trace started by the tracer has finished, such as when the span
+// of the Middleware finishes after all client spans of the request. The spans
+// are then emitted if any of the rules match and are dropped otherwise. This
+// keeps rare slow or failed requests that head sampling would likely discard.
+// Spans that were not sampled when started are never emitted. The number of
+// traces held is limited by TracerOptionMaxOpenTraces.
+func TracerOptionTailSampling(rules ...TailSamplingRule) TracerOption {
+	return func(t *tracer) *tracer {
+		t.tailRules = rules
+		return t
+	}
+}
+
+// tailSampler implements the zipkin-go Reporter interface by buffering the
+// spans of each trace and sending them to the wrapped reporter only when a
+// sampling rule matches the complete trace.
+type tailSampler struct {
+	wrapped reporter.Reporter
+	rules   []TailSamplingRule
+	lock    sync.Mutex
+	traces  map[model.TraceID][]bufferedSpan
+}
+
+// bufferedSpan is a span held until its trace is complete along with the
+// fields of its logs.
+type bufferedSpan struct {
+	span model.SpanModel
+	logs [][]log.Field
+}
+
+func newTailSampler(wrapped reporter.Reporter, rules []TailSamplingRule) *tailSampler {
+	return &tailSampler{
+		wrapped: wrapped,
+		rules:   rules,
+		traces:  make(map[model.TraceID][]bufferedSpan),
+	}
+}
+
+func (s *tailSampler) Send(span model.SpanModel) {
+	s.SendWithLogs(span, nil)
+}
+
+// SendWithLogs buffers the span along with the fields of its logs.
+func (s *tailSampler) SendWithLogs(span model.SpanModel, logs [][]log.Field) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.traces[span.TraceID] = append(s.traces[span.TraceID], bufferedSpan{span: span, logs: logs})
+}
+
+// FinishTrace applies the rules to the buffered spans of the trace.
+func (s *tailSampler) FinishTrace(id model.TraceID) {
+	s.lock.Lock()
+	var buffered = s.traces[id]
+	delete(s.traces, id)
+	s.lock.Unlock()
+	if s.keep(buffered) {
+		for _, b := range buffered {
+			sendSpan(s.wrapped, b.span, b.logs)
+		}
+	} else {
+		atomic.AddUint64(&stats.SpansDropped, uint64(len(buffered)))
+	}
+	if tr, ok := s.wrapped.(traceReporter); ok {
+		tr.FinishTrace(id)
+	}
+}
+
+func (s *tailSampler) keep(buffered []bufferedSpan) bool {
+	var spans = make([]model.SpanModel, 0, len(buffered))
+	for _, b := range buffered {
+		spans = append(spans, b.span)
+	}
+	for _, rule := range s.rules {
+		if rule(spans) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close applies the rules to the buffered spans of each incomplete trace and
+// closes the wrapped reporter.
+func (s *tailSampler) Close() error {
+	s.lock.Lock()
+	var ids = make([]model.TraceID, 0, len(s.traces))
+	for id := range s.traces {
+		ids = append(ids, id)
+	}
+	s.lock.Unlock()
+	for _, id := range ids {
+		s.FinishTrace(id)
+	}
+	return s.wrapped.Close()
+}
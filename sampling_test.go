@@ -0,0 +1,116 @@
+package httptrace
+
+import (
+	"testing"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+func TestTailSampling(t *testing.T) {
+	var recorder = NewRecorder()
+	var tr, _ = NewTracer(nil, "testservice", "127.0.0.1:8080",
+		TracerOptionReporter(recorder),
+		TracerOptionTailSampling(TailSampleErrors(), TailSampleSlowerThan(time.Second), TailSampleStatusClass(5)),
+	)
+	var start = time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	var trace = func(tag func(opentracing.Span), duration time.Duration) {
+		var root = tr.StartSpan("root", opentracing.StartTime(start))
+		var child = tr.StartSpan("child", opentracing.ChildOf(root.Context()), opentracing.StartTime(start))
+		tag(child)
+		child.FinishWithOptions(opentracing.FinishOptions{FinishTime: start.Add(duration)})
+		if len(recorder.Spans()) != 0 {
+			t.Fatal("spans were emitted before the trace was complete")
+		}
+		root.FinishWithOptions(opentracing.FinishOptions{FinishTime: start.Add(duration)})
+	}
+
+	trace(func(opentracing.Span) {}, time.Millisecond)
+	if len(recorder.Spans()) != 0 {
+		t.Errorf("expected an unremarkable trace to be dropped but got %d spans", len(recorder.Spans()))
+	}
+	trace(func(s opentracing.Span) { ext.HTTPStatusCode.Set(s, uint16(404)) }, time.Millisecond)
+	if len(recorder.Spans()) != 0 {
+		t.Errorf("expected a 4xx trace to be dropped but got %d spans", len(recorder.Spans()))
+	}
+	for _, tc := range []struct {
+		name     string
+		tag      func(opentracing.Span)
+		duration time.Duration
+	}{
+		{"error", func(s opentracing.Span) { ext.Error.Set(s, true) }, time.Millisecond},
+		{"slow", func(opentracing.Span) {}, 2 * time.Second},
+		{"status", func(s opentracing.Span) { ext.HTTPStatusCode.Set(s, uint16(503)) }, time.Millisecond},
+	} {
+		recorder.Reset()
+		trace(tc.tag, tc.duration)
+		if len(recorder.Spans()) != 2 {
+			t.Errorf("%s: expected the whole trace to be kept but got %d spans", tc.name, len(recorder.Spans()))
+		}
+	}
+}
+
+func TestTailSamplingUnfinishedSpan(t *testing.T) {
+	var recorder = NewRecorder()
+	var tr, _ = NewTracer(nil, "testservice", "127.0.0.1:8080",
+		TracerOptionReporter(recorder),
+		TracerOptionTailSampling(TailSampleErrors()),
+		TracerOptionMaxOpenTraces(1),
+	)
+	var sampler = tr.(*tracer).reporter.(*tailSampler)
+
+	// The roots of these traces are never finished.
+	var failed = tr.StartSpan("root")
+	var child = tr.StartSpan("child", opentracing.ChildOf(failed.Context()))
+	ext.Error.Set(child, true)
+	child.Finish()
+	if len(recorder.Spans()) != 0 {
+		t.Fatal("spans were emitted before the trace was complete")
+	}
+	var plain = tr.StartSpan("root")
+	if len(recorder.Spans()) != 1 {
+		t.Fatalf("expected the oldest trace to be sampled when evicted but got %d spans", len(recorder.Spans()))
+	}
+	tr.StartSpan("child", opentracing.ChildOf(plain.Context())).Finish()
+
+	var before = ReadStats()
+	tr.StartSpan("root")
+	var after = ReadStats()
+	if after.SpansDropped-before.SpansDropped != 1 {
+		t.Errorf("expected 1 dropped span but got %d", after.SpansDropped-before.SpansDropped)
+	}
+	if len(recorder.Spans()) != 1 {
+		t.Errorf("expected the unremarkable trace to be dropped but got %d spans", len(recorder.Spans()))
+	}
+	if len(sampler.traces) != 0 || len(tr.(*tracer).traces.open) != 1 {
+		t.Errorf("expected only the newest trace to be held but found %d buffered and %d open",
+			len(sampler.traces), len(tr.(*tracer).traces.open))
+	}
+}
+
+func TestTailSamplingClose(t *testing.T) {
+	var recorder = NewRecorder()
+	var teardown, _ = SetupGlobalTracer(nil, "testservice", "127.0.0.1:8080",
+		TracerOptionReporter(recorder),
+		TracerOptionTailSampling(TailSampleErrors()),
+	)
+	var tr = opentracing.GlobalTracer()
+
+	// The roots of these traces are never finished.
+	var failed = tr.StartSpan("root")
+	var child = tr.StartSpan("child", opentracing.ChildOf(failed.Context()))
+	ext.Error.Set(child, true)
+	child.Finish()
+	var plain = tr.StartSpan("root")
+	tr.StartSpan("child", opentracing.ChildOf(plain.Context())).Finish()
+	if len(recorder.Spans()) != 0 {
+		t.Fatal("spans were emitted before the trace was complete")
+	}
+	if err := teardown(); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.Spans()) != 1 || recorder.Spans()[0].Name != "child" || recorder.Spans()[0].TraceID != child.Context().(spanContext).TraceID {
+		t.Errorf("expected the failed trace to be sampled on close but got %v", recorder.Spans())
+	}
+}
@@ -0,0 +1,245 @@
+package httptrace
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logfmt/logfmt"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+// now is the clock used for span timestamps.
+var now = time.Now
+
+// spanContext implements opentracing.SpanContext on top of the zipkin model.
+type spanContext struct {
+	model.SpanContext
+	baggage map[string]string
+}
+
+// ForeachBaggageItem belongs to the opentracing.SpanContext interface.
+func (c spanContext) ForeachBaggageItem(handler func(k, v string) bool) {
+	for k, v := range c.baggage {
+		if !handler(k, v) {
+			break
+		}
+	}
+}
+
+// withSpanContext returns a copy of the context with new identifiers but the
+// same baggage.
+func (c spanContext) withSpanContext(sc model.SpanContext) spanContext {
+	return spanContext{SpanContext: sc, baggage: c.baggage}
+}
+
+// withBaggageItem returns a copy of the context with the baggage item set.
+func (c spanContext) withBaggageItem(key, value string) spanContext {
+	var baggage = make(map[string]string, len(c.baggage)+1)
+	for k, v := range c.baggage {
+		baggage[k] = v
+	}
+	baggage[key] = value
+	return spanContext{SpanContext: c.SpanContext, baggage: baggage}
+}
+
+// span implements opentracing.Span by recording a zipkin SpanModel that is
+// sent to the reporter of the tracer when finished.
+type span struct {
+	tracer  *tracer
+	lock    sync.Mutex
+	context spanContext
+	model   model.SpanModel
+	// logs holds the fields of each annotation of the model.
+	logs     [][]log.Field
+	finished bool
+	// metricsKind and metricsStatus describe the span to its metrics when the
+	// legacy format leaves the kind and status out of the span itself.
+	metricsKind   model.Kind
+	metricsStatus int
+}
+
+// Tracer belongs to the opentracing.Span interface.
+func (s *span) Tracer() opentracing.Tracer {
+	return s.tracer
+}
+
+// Context belongs to the opentracing.Span interface.
+func (s *span) Context() opentracing.SpanContext {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.context
+}
+
+// SetOperationName belongs to the opentracing.Span interface.
+func (s *span) SetOperationName(operationName string) opentracing.Span {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.model.Name = operationName
+	return s
+}
+
+// SetTag belongs to the opentracing.Span interface. The span.kind and
+// peer.service tags are recorded as the kind and remote endpoint of the span
+// rather than as tags.
+func (s *span) SetTag(key string, value interface{}) opentracing.Span {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch key {
+	case string(ext.SpanKind):
+		var kind = model.Kind(strings.ToUpper(fmt.Sprintf("%+v", value)))
+		switch kind {
+		case model.Client, model.Server, model.Producer, model.Consumer:
+			s.model.Kind = kind
+			return s
+		}
+	case string(ext.PeerService):
+		var remote = model.Endpoint{}
+		if s.model.RemoteEndpoint != nil {
+			remote = *s.model.RemoteEndpoint
+		}
+		remote.ServiceName = fmt.Sprintf("%+v", value)
+		s.model.RemoteEndpoint = &remote
+		return s
+	case string(ext.SamplingPriority):
+		if priority, ok := value.(uint16); ok {
+			var sampled = priority != 0
+			s.context.Sampled = &sampled
+			return s
+		}
+	}
+	if s.model.Tags == nil {
+		s.model.Tags = make(map[string]string)
+	}
+	s.model.Tags[key] = fmt.Sprintf("%+v", value)
+	return s
+}
+
+// LogFields belongs to the opentracing.Span interface.
+func (s *span) LogFields(fields ...log.Field) {
+	s.log(now(), fields)
+}
+
+// LogKV belongs to the opentracing.Span interface.
+func (s *span) LogKV(alternatingKeyValues ...interface{}) {
+	var fields, err = log.InterleavedKVToFields(alternatingKeyValues...)
+	if err != nil {
+		s.LogFields(log.Error(err), log.String("function", "LogKV"))
+		return
+	}
+	s.LogFields(fields...)
+}
+
+// log records the fields as an annotation. A single event field is recorded
+// as its value and any other set of fields is recorded in logfmt.
+func (s *span) log(timestamp time.Time, fields []log.Field) {
+	if len(fields) < 1 {
+		return
+	}
+	var value string
+	if len(fields) == 1 && fields[0].Key() == "event" {
+		value = fmt.Sprintf("%+v", fields[0].Value())
+	} else {
+		var buffer = bytes.NewBuffer(nil)
+		var encoder = logfmt.NewEncoder(buffer)
+		for _, field := range fields {
+			if err := encoder.EncodeKeyval(field.Key(), field.Value()); err != nil {
+				_ = encoder.EncodeKeyval(field.Key(), err.Error())
+			}
+		}
+		value = buffer.String()
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.model.Annotations = append(s.model.Annotations, model.Annotation{
+		Timestamp: timestamp,
+		Value:     value,
+	})
+	s.logs = append(s.logs, fields)
+}
+
+// SetBaggageItem belongs to the opentracing.Span interface.
+func (s *span) SetBaggageItem(restrictedKey, value string) opentracing.Span {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.context = s.context.withBaggageItem(restrictedKey, value)
+	return s
+}
+
+// BaggageItem belongs to the opentracing.Span interface.
+func (s *span) BaggageItem(restrictedKey string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.context.baggage[restrictedKey]
+}
+
+// Finish belongs to the opentracing.Span interface.
+func (s *span) Finish() {
+	s.FinishWithOptions(opentracing.FinishOptions{})
+}
+
+// FinishWithOptions belongs to the opentracing.Span interface. Spans are only
+// reported once and only if they are sampled.
+func (s *span) FinishWithOptions(opts opentracing.FinishOptions) {
+	var finishTime = opts.FinishTime
+	if finishTime.IsZero() {
+		finishTime = now()
+	}
+	for _, record := range opts.LogRecords {
+		s.log(record.Timestamp, record.Fields)
+	}
+	for _, data := range opts.BulkLogData {
+		var record = data.ToLogRecord()
+		s.log(record.Timestamp, record.Fields)
+	}
+	s.lock.Lock()
+	if s.finished {
+		s.lock.Unlock()
+		return
+	}
+	s.finished = true
+	s.model.SpanContext = s.context.SpanContext
+	s.model.Duration = finishTime.Sub(s.model.Timestamp)
+	var sampled = s.context.Debug || s.context.Sampled == nil || *s.context.Sampled
+	var finished = s.model
+	var logs = s.logs
+	var metricsKind, metricsStatus = s.metricsKind, s.metricsStatus
+	s.lock.Unlock()
+	atomic.AddUint64(&stats.SpansFinished, 1)
+	if len(s.tracer.metrics) > 0 {
+		observeSpan(s.tracer.metrics, finished, metricsKind, metricsStatus)
+	}
+	if sampled {
+		sendSpan(s.tracer.reporter, finished, logs)
+	} else {
+		atomic.AddUint64(&stats.SpansDropped, 1)
+	}
+	if s.tracer.traces != nil && s.tracer.traces.finish(finished.TraceID) {
+		s.tracer.reporter.(traceReporter).FinishTrace(finished.TraceID)
+	}
+}
+
+// LogEvent belongs to the opentracing.Span interface.
+func (s *span) LogEvent(event string) {
+	s.Log(opentracing.LogData{Event: event})
+}
+
+// LogEventWithPayload belongs to the opentracing.Span interface.
+func (s *span) LogEventWithPayload(event string, payload interface{}) {
+	s.Log(opentracing.LogData{Event: event, Payload: payload})
+}
+
+// Log belongs to the opentracing.Span interface.
+func (s *span) Log(data opentracing.LogData) {
+	if data.Timestamp.IsZero() {
+		data.Timestamp = now()
+	}
+	var record = data.ToLogRecord()
+	s.log(record.Timestamp, record.Fields)
+}
@@ -0,0 +1,114 @@
+package httptrace
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+// Stats is a snapshot of the counters that describe the tracing performed by
+// this package across the whole process.
+type Stats struct {
+	// SpansStarted counts the spans started by tracers of this package.
+	SpansStarted uint64 `json:"spansStarted"`
+	// SpansFinished counts the spans finished by tracers of this package.
+	SpansFinished uint64 `json:"spansFinished"`
+	// SpansCollected counts the finished spans delivered to the logs or to a
+	// reporter.
+	SpansCollected uint64 `json:"spansCollected"`
+	// SpansDropped counts the finished spans that were not delivered because
+	// they were not sampled or were discarded by tail sampling.
+	SpansDropped uint64 `json:"spansDropped"`
+	// ExtractFailures counts incoming requests with trace headers that could
+	// not be parsed by the Middleware.
+	ExtractFailures uint64 `json:"extractFailures"`
+	// InjectFailures counts outgoing requests for which the Transport could
+	// not write trace headers.
+	InjectFailures uint64 `json:"injectFailures"`
+	// CollectorErrors counts failures of the logs or reporters while
+	// delivering or closing.
+	CollectorErrors uint64 `json:"collectorErrors"`
+}
+
+// stats holds the live counters and is only accessed atomically.
+var stats Stats
+
+// ReadStats returns the current values of the tracing counters.
+func ReadStats() Stats {
+	return Stats{
+		SpansStarted:    atomic.LoadUint64(&stats.SpansStarted),
+		SpansFinished:   atomic.LoadUint64(&stats.SpansFinished),
+		SpansCollected:  atomic.LoadUint64(&stats.SpansCollected),
+		SpansDropped:    atomic.LoadUint64(&stats.SpansDropped),
+		ExtractFailures: atomic.LoadUint64(&stats.ExtractFailures),
+		InjectFailures:  atomic.LoadUint64(&stats.InjectFailures),
+		CollectorErrors: atomic.LoadUint64(&stats.CollectorErrors),
+	}
+}
+
+// StatsHandler returns an http.Handler that writes the current values of the
+// tracing counters as a JSON object.
+func StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ReadStats())
+	})
+}
+
+// countingReporter implements the zipkin-go Reporter interface by counting
+// the spans delivered to the wrapped reporter. A reporter that panics is
+// counted as a collector error rather than failing the request.
+type countingReporter struct {
+	wrapped reporter.Reporter
+}
+
+func (r *countingReporter) Send(s model.SpanModel) {
+	r.SendWithLogs(s, nil)
+}
+
+// SendWithLogs forwards the fields of the span logs to the wrapped reporter.
+func (r *countingReporter) SendWithLogs(s model.SpanModel, logs [][]log.Field) {
+	defer func() {
+		if p := recover(); p != nil {
+			atomic.AddUint64(&stats.CollectorErrors, 1)
+		}
+	}()
+	sendSpan(r.wrapped, s, logs)
+	atomic.AddUint64(&stats.SpansCollected, 1)
+}
+
+func (r *countingReporter) Close() error {
+	var err = closeReporter(r.wrapped)
+	if err != nil {
+		atomic.AddUint64(&stats.CollectorErrors, 1)
+	}
+	return err
+}
+
+// countingTraceReporter is a countingReporter for reporters that act on
+// complete traces.
+type countingTraceReporter struct {
+	countingReporter
+}
+
+func (r *countingTraceReporter) FinishTrace(id model.TraceID) {
+	defer func() {
+		if p := recover(); p != nil {
+			atomic.AddUint64(&stats.CollectorErrors, 1)
+		}
+	}()
+	r.wrapped.(traceReporter).FinishTrace(id)
+}
+
+// newCountingReporter wraps the reporter in order to count its deliveries and
+// failures.
+func newCountingReporter(wrapped reporter.Reporter) reporter.Reporter {
+	if _, ok := wrapped.(traceReporter); ok {
+		return &countingTraceReporter{countingReporter{wrapped: wrapped}}
+	}
+	return &countingReporter{wrapped: wrapped}
+}
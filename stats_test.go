@@ -0,0 +1,64 @@
+package httptrace
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+type panicReporter struct{}
+
+func (panicReporter) Send(model.SpanModel) { panic("send failure") }
+func (panicReporter) Close() error         { return nil }
+
+func TestStats(t *testing.T) {
+	var before = ReadStats()
+
+	var tr, _ = NewTracer(nil, "testservice", "127.0.0.1:8080", TracerOptionReporter(NewRecorder()))
+	tr.StartSpan("collected").Finish()
+	var dropped = tr.StartSpan("dropped")
+	dropped.SetTag("sampling.priority", uint16(0))
+	dropped.Finish()
+	var failing, _ = NewTracer(nil, "testservice", "127.0.0.1:8080", TracerOptionReporter(panicReporter{}))
+	failing.StartSpan("failed").Finish()
+
+	var handler = NewMiddleware(
+		MiddlewareOptionTracerOptions(TracerOptionReporter(NewRecorder())),
+	)(&fixtureHandler{})
+	var r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Set("X-B3-TraceId", "not-hex")
+	r.Header.Set("X-B3-SpanId", "0000000000000002")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	var after = ReadStats()
+	for _, tc := range []struct {
+		name     string
+		before   uint64
+		after    uint64
+		expected uint64
+	}{
+		{"started", before.SpansStarted, after.SpansStarted, 4},
+		{"finished", before.SpansFinished, after.SpansFinished, 4},
+		{"collected", before.SpansCollected, after.SpansCollected, 2},
+		{"dropped", before.SpansDropped, after.SpansDropped, 1},
+		{"collector errors", before.CollectorErrors, after.CollectorErrors, 1},
+		{"extract failures", before.ExtractFailures, after.ExtractFailures, 1},
+	} {
+		if tc.after-tc.before != tc.expected {
+			t.Errorf("expected %s to increase by %d but it increased by %d", tc.name, tc.expected, tc.after-tc.before)
+		}
+	}
+
+	var w = httptest.NewRecorder()
+	StatsHandler().ServeHTTP(w, r)
+	var served Stats
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil {
+		t.Fatal(err)
+	}
+	if served.SpansStarted < after.SpansStarted {
+		t.Errorf("handler served %d started spans but %d were counted", served.SpansStarted, after.SpansStarted)
+	}
+}
@@ -1,15 +1,440 @@
 package httptrace
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
 	"github.com/asecurityteam/logevent"
 	opentracing "github.com/opentracing/opentracing-go"
-	zipkin "github.com/openzipkin/zipkin-go-opentracing"
+	"github.com/opentracing/opentracing-go/log"
+	zipkin "github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/idgenerator"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/propagation/b3"
+	"github.com/openzipkin/zipkin-go/reporter"
 )
 
+const baggageHeaderPrefix = "ot-baggage-"
+
+// TracerOption is a configuration setting for the tracer.
+type TracerOption func(*tracer) *tracer
+
+// TracerOptionLegacyFormat sets whether finished spans are logged in the
+// format emitted by versions of this package that were based on the
+// zipkin-go-opentracing library. That format uses the Zipkin V1 model of
+// annotations and binary annotations. The default value of this option is
+// false, which logs spans using the Zipkin V2 model.
+func TracerOptionLegacyFormat(legacy bool) TracerOption {
+	return func(t *tracer) *tracer {
+		t.legacyFormat = legacy
+		return t
+	}
+}
+
+// TracerOptionLogMessage sets the message of the log event emitted for each
+// finished span. The default value of this option is span-complete.
+func TracerOptionLogMessage(message string) TracerOption {
+	return func(t *tracer) *tracer {
+		t.logMessage = message
+		return t
+	}
+}
+
+// TracerOptionLogSpanKey sets the key of the log event under which the span is
+// emitted. The default value of this option is zipkin.
+func TracerOptionLogSpanKey(key string) TracerOption {
+	return func(t *tracer) *tracer {
+		t.logSpanKey = key
+		return t
+	}
+}
+
+// TracerOptionLogLevel sets the level, one of debug, info, warn, or error, at
+// which finished spans are logged. The default value of this option is info.
+func TracerOptionLogLevel(level string) TracerOption {
+	return func(t *tracer) *tracer {
+		t.logLevel = level
+		return t
+	}
+}
+
+// TracerOptionLogFlatten sets whether the fields of the span are placed at the
+// top level of the log event instead of under the span key. The default value
+// of this option is false.
+func TracerOptionLogFlatten(flatten bool) TracerOption {
+	return func(t *tracer) *tracer {
+		t.logFlatten = flatten
+		return t
+	}
+}
+
+// TracerOptionDevelopment replaces the structured span logs with a compact,
+// human readable tree of the spans of each trace that is written to the given
+// Writer once every span of the trace started by the tracer has finished. This
+// is intended for running services locally. The Logger and any other log
+// options are not used. A nil Writer selects os.Stderr.
+func TracerOptionDevelopment(w io.Writer) TracerOption {
+	return func(t *tracer) *tracer {
+		if w == nil {
+			w = os.Stderr
+		}
+		t.devWriter = w
+		return t
+	}
+}
+
+// TracerOptionMaxOpenTraces limits the number of incomplete traces whose
+// spans are held in memory by tail sampling or the development output. When
+// the limit is exceeded the oldest trace is handled as if it were complete, so
+// its finished spans are sampled or written, and any of its spans that finish
+// later are handled as a trace of their own. This bounds the memory used when
+// spans are never finished. The default limit is 10000 traces.
+func TracerOptionMaxOpenTraces(max int) TracerOption {
+	return func(t *tracer) *tracer {
+		t.maxOpenTraces = max
+		return t
+	}
+}
+
 // NewTracer generates an opentracing.Tracer implementation that uses the given
-// Logger and metadata when generating and emitting spans.
-func NewTracer(logger logevent.Logger, serviceName string, hostPort string) (opentracing.Tracer, error) {
-	var collector = &collector{logger}
-	var recorder = zipkin.NewRecorder(collector, false, hostPort, serviceName)
-	return zipkin.NewTracer(recorder)
+// Logger and metadata when generating and emitting spans. An error is returned
+// if the options are invalid, such as an unknown log level, or if the Logger is
+// nil while spans are emitted to the logs.
+func NewTracer(logger logevent.Logger, serviceName string, hostPort string, options ...TracerOption) (opentracing.Tracer, error) {
+	var endpoint, err = zipkin.NewEndpoint(serviceName, hostPort)
+	if err != nil || endpoint == nil {
+		// Matching the behaviour of previous versions, an unresolvable
+		// host:port does not prevent tracing. The endpoint is left undefined
+		// except for the service name.
+		endpoint = &model.Endpoint{ServiceName: serviceName}
+	}
+	var t = &tracer{
+		endpoint:      endpoint,
+		idGenerator:   idgenerator.NewRandom64(),
+		logMessage:    defaultSpanMessage,
+		logSpanKey:    defaultSpanKey,
+		logLevel:      defaultSpanLevel,
+		maxOpenTraces: defaultMaxOpenTraces,
+	}
+	for _, option := range options {
+		t = option(t)
+	}
+	switch {
+	case t.customReporter != nil:
+		t.reporter = t.customReporter
+	case t.devWriter != nil:
+		t.reporter = newDevCollector(t.devWriter)
+	default:
+		var c, er = newCollector(logger, t)
+		if er != nil {
+			return nil, er
+		}
+		t.reporter = c
+	}
+	if len(t.extraReporters) > 0 {
+		t.reporter = NewMultiReporter(nil, append([]reporter.Reporter{t.reporter}, t.extraReporters...)...)
+	}
+	t.reporter = newCountingReporter(t.reporter)
+	if len(t.tailRules) > 0 {
+		t.reporter = newTailSampler(t.reporter, t.tailRules)
+	}
+	if _, ok := t.reporter.(traceReporter); ok {
+		t.traces = newTraceCounter(t.maxOpenTraces)
+	}
+	return t, nil
+}
+
+// usesLogger reports whether a tracer built with the given options emits spans
+// through its Logger rather than a reporter or development Writer.
+func usesLogger(options []TracerOption) bool {
+	var t = &tracer{}
+	for _, option := range options {
+		t = option(t)
+	}
+	return t.customReporter == nil && t.devWriter == nil
+}
+
+// tracer implements opentracing.Tracer using the Zipkin V2 span model. Spans
+// are reported to a zipkin-go reporter when finished.
+type tracer struct {
+	reporter     reporter.Reporter
+	endpoint     *model.Endpoint
+	idGenerator  idgenerator.IDGenerator
+	legacyFormat bool
+	logMessage   string
+	logSpanKey   string
+	logLevel     string
+	logFlatten   bool
+	devWriter    io.Writer
+	// customReporter replaces the log based reporter when set.
+	customReporter reporter.Reporter
+	// extraReporters also receive every span when set.
+	extraReporters []reporter.Reporter
+	// tailRules enable tail based sampling when set.
+	tailRules []TailSamplingRule
+	// metrics observe every finished span, whether or not it is sampled, when
+	// set.
+	metrics []SpanMetrics
+	// traces counts the open spans of each trace when the reporter needs to
+	// know when a trace is complete.
+	traces *traceCounter
+	// maxOpenTraces bounds the number of traces counted by traces.
+	maxOpenTraces int
+}
+
+// traceReporter is implemented by reporters that act on the spans of a trace
+// once every span of that trace started by the tracer has finished.
+type traceReporter interface {
+	reporter.Reporter
+	FinishTrace(model.TraceID)
+}
+
+// logReporter is implemented by reporters that use the original fields of the
+// span logs, which the annotations of a SpanModel only contain as text. The
+// fields of each log are given in the order of the annotations.
+type logReporter interface {
+	reporter.Reporter
+	SendWithLogs(s model.SpanModel, logs [][]log.Field)
+}
+
+// sendSpan delivers the span to the reporter along with the fields of its logs
+// if the reporter uses them.
+func sendSpan(r reporter.Reporter, s model.SpanModel, logs [][]log.Field) {
+	if lr, ok := r.(logReporter); ok {
+		lr.SendWithLogs(s, logs)
+		return
+	}
+	r.Send(s)
+}
+
+// defaultMaxOpenTraces is the number of incomplete traces that are counted
+// before the oldest is handled as complete.
+const defaultMaxOpenTraces = 10000
+
+// traceCounter tracks the number of unfinished spans of each trace.
+type traceCounter struct {
+	lock sync.Mutex
+	max  int
+	seq  uint64
+	open map[model.TraceID]*openTrace
+	// order contains the open traces in the order they were started. It may
+	// also contain traces that have since completed, which are skipped.
+	order []traceStart
+}
+
+type openTrace struct {
+	spans int
+	seq   uint64
+}
+
+type traceStart struct {
+	id  model.TraceID
+	seq uint64
+}
+
+func newTraceCounter(max int) *traceCounter {
+	return &traceCounter{max: max, open: make(map[model.TraceID]*openTrace)}
+}
+
+// start records a new span of the trace. If this exceeds the limit of open
+// traces then the oldest trace is no longer counted and is returned so that it
+// can be handled as complete.
+func (c *traceCounter) start(id model.TraceID) (model.TraceID, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var trace, ok = c.open[id]
+	if !ok {
+		c.seq = c.seq + 1
+		trace = &openTrace{seq: c.seq}
+		c.open[id] = trace
+		c.order = append(c.order, traceStart{id: id, seq: trace.seq})
+	}
+	trace.spans = trace.spans + 1
+	if len(c.order) > 2*len(c.open) {
+		c.compact()
+	}
+	if c.max < 1 || len(c.open) <= c.max {
+		return model.TraceID{}, false
+	}
+	for len(c.order) > 0 {
+		var oldest = c.order[0]
+		c.order = c.order[1:]
+		if c.isOpen(oldest) {
+			delete(c.open, oldest.id)
+			return oldest.id, true
+		}
+	}
+	return model.TraceID{}, false
+}
+
+// finish records a finished span of the trace and reports whether it was the
+// last open span of that trace. A span of a trace that is no longer counted is
+// the last of its trace.
+func (c *traceCounter) finish(id model.TraceID) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var trace, ok = c.open[id]
+	if !ok {
+		return true
+	}
+	trace.spans = trace.spans - 1
+	if trace.spans > 0 {
+		return false
+	}
+	delete(c.open, id)
+	return true
+}
+
+func (c *traceCounter) isOpen(s traceStart) bool {
+	var trace, ok = c.open[s.id]
+	return ok && trace.seq == s.seq
+}
+
+// compact removes the completed traces from the start order.
+func (c *traceCounter) compact() {
+	var order = make([]traceStart, 0, len(c.open))
+	for _, s := range c.order {
+		if c.isOpen(s) {
+			order = append(order, s)
+		}
+	}
+	c.order = order
+}
+
+// StartSpan belongs to the opentracing.Tracer interface.
+func (t *tracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	var options = opentracing.StartSpanOptions{}
+	for _, opt := range opts {
+		opt.Apply(&options)
+	}
+	var sc spanContext
+	var parent, ok = parentContext(options.References)
+	if ok {
+		var parentID = parent.ID
+		sc = parent.withSpanContext(model.SpanContext{
+			TraceID:  parent.TraceID,
+			ID:       t.idGenerator.SpanID(model.TraceID{}),
+			ParentID: &parentID,
+			Debug:    parent.Debug,
+			Sampled:  parent.Sampled,
+		})
+	} else {
+		var traceID = t.idGenerator.TraceID()
+		sc = spanContext{SpanContext: model.SpanContext{
+			TraceID: traceID,
+			ID:      t.idGenerator.SpanID(traceID),
+		}}
+	}
+	if sc.Sampled == nil {
+		var sampled = true
+		sc.Sampled = &sampled
+	}
+	var startTime = options.StartTime
+	if startTime.IsZero() {
+		startTime = now()
+	}
+	var s = &span{
+		tracer:  t,
+		context: sc,
+		model: model.SpanModel{
+			Name:          operationName,
+			Timestamp:     startTime,
+			LocalEndpoint: t.endpoint,
+		},
+	}
+	for key, value := range options.Tags {
+		s.SetTag(key, value)
+	}
+	if t.traces != nil {
+		if evicted, ok := t.traces.start(sc.TraceID); ok {
+			t.reporter.(traceReporter).FinishTrace(evicted)
+		}
+	}
+	atomic.AddUint64(&stats.SpansStarted, 1)
+	return s
+}
+
+// parentContext selects the first reference that was generated by this
+// tracer. Contexts of other tracers cannot be used as parents.
+func parentContext(references []opentracing.SpanReference) (spanContext, bool) {
+	for _, ref := range references {
+		if sc, ok := ref.ReferencedContext.(spanContext); ok {
+			return sc, true
+		}
+	}
+	return spanContext{}, false
+}
+
+// Inject belongs to the opentracing.Tracer interface. The TextMap and
+// HTTPHeaders formats are supported and both use B3 headers along with
+// ot-baggage- prefixed headers for baggage.
+func (t *tracer) Inject(sm opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	if format != opentracing.TextMap && format != opentracing.HTTPHeaders {
+		return opentracing.ErrUnsupportedFormat
+	}
+	var writer, ok = carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+	var sc spanContext
+	if sc, ok = sm.(spanContext); !ok {
+		return opentracing.ErrInvalidSpanContext
+	}
+	var headers = b3.Map{}
+	if err := headers.Inject()(sc.SpanContext); err != nil {
+		return err
+	}
+	for k, v := range headers {
+		writer.Set(k, v)
+	}
+	for k, v := range sc.baggage {
+		writer.Set(baggageHeaderPrefix+k, v)
+	}
+	return nil
+}
+
+// Extract belongs to the opentracing.Tracer interface. Carriers without any
+// trace identifiers result in opentracing.ErrSpanContextNotFound while
+// identifiers that cannot be parsed result in an error that wraps
+// opentracing.ErrSpanContextCorrupted.
+func (t *tracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	if format != opentracing.TextMap && format != opentracing.HTTPHeaders {
+		return nil, opentracing.ErrUnsupportedFormat
+	}
+	var reader, ok = carrier.(opentracing.TextMapReader)
+	if !ok {
+		return nil, opentracing.ErrInvalidCarrier
+	}
+	var headers = b3.Map{}
+	var baggage map[string]string
+	var err = reader.ForeachKey(func(k, v string) error {
+		var key = strings.ToLower(k)
+		if strings.HasPrefix(key, baggageHeaderPrefix) {
+			if baggage == nil {
+				baggage = make(map[string]string)
+			}
+			baggage[strings.TrimPrefix(key, baggageHeaderPrefix)] = v
+			return nil
+		}
+		headers[key] = v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if headers[b3.TraceID] == "" && headers[b3.SpanID] == "" && headers[b3.Context] == "" {
+		return nil, opentracing.ErrSpanContextNotFound
+	}
+	var msc, er = headers.Extract()
+	if er != nil {
+		return nil, fmt.Errorf("%w: %s", opentracing.ErrSpanContextCorrupted, er.Error())
+	}
+	if msc == nil || msc.TraceID.Empty() || msc.ID == 0 {
+		return nil, opentracing.ErrSpanContextNotFound
+	}
+	return spanContext{SpanContext: *msc, baggage: baggage}, nil
 }
@@ -0,0 +1,117 @@
+package httptrace
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+func TestTracerInjectExtract(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var tracer, _ = NewTracer(NewMockLogger(ctrl), "testservice", "127.0.0.1:8080")
+	var span = tracer.StartSpan("test")
+	span.SetBaggageItem("user", "someone")
+	var header = http.Header{}
+	if err := tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header)); err != nil {
+		t.Fatal(err)
+	}
+	var sc, err = tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = span.Context().(spanContext)
+	var actual = sc.(spanContext)
+	if actual.TraceID != expected.TraceID || actual.ID != expected.ID {
+		t.Errorf("expected %v but extracted %v", expected.SpanContext, actual.SpanContext)
+	}
+	if actual.baggage["user"] != "someone" {
+		t.Errorf("expected baggage user=someone but got %v", actual.baggage)
+	}
+}
+
+func TestTracerExtractErrors(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var tracer, _ = NewTracer(NewMockLogger(ctrl), "testservice", "127.0.0.1:8080")
+	var header = http.Header{}
+	var _, err = tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+	if err != opentracing.ErrSpanContextNotFound {
+		t.Errorf("expected ErrSpanContextNotFound but got %v", err)
+	}
+	header.Set("X-B3-TraceId", "not-hex")
+	header.Set("X-B3-SpanId", "0000000000000002")
+	_, err = tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+	if !errors.Is(err, opentracing.ErrSpanContextCorrupted) {
+		t.Errorf("expected ErrSpanContextCorrupted but got %v", err)
+	}
+}
+
+func TestTracerSpanModel(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var logger = NewMockLogger(ctrl)
+	logger.EXPECT().Info(gomock.Any()).Do(func(event interface{}) {
+		var evt = event.(v2Frame)
+		if evt.Zipkin.Kind != string(model.Client) {
+			t.Errorf("expected kind CLIENT but got %s", evt.Zipkin.Kind)
+		}
+		if evt.Zipkin.RemoteEndpoint.ServiceName != "dependency" {
+			t.Errorf("expected remote endpoint dependency but got %v", evt.Zipkin.RemoteEndpoint)
+		}
+		if _, ok := evt.Zipkin.Tags[string(ext.SpanKind)]; ok {
+			t.Error("span.kind was recorded as a tag")
+		}
+		if evt.Zipkin.Tags["component"] != "test" {
+			t.Errorf("expected tag component=test but got %v", evt.Zipkin.Tags)
+		}
+	})
+	var tracer, _ = NewTracer(logger, "testservice", "127.0.0.1:8080")
+	var span = tracer.StartSpan("test", ext.SpanKindRPCClient, opentracing.Tag{Key: "component", Value: "test"})
+	ext.PeerService.Set(span, "dependency")
+	span.Finish()
+	span.Finish()
+}
+
+func TestTracerUnsampledNotReported(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var tracer, _ = NewTracer(NewMockLogger(ctrl), "testservice", "127.0.0.1:8080")
+	var span = tracer.StartSpan("test")
+	ext.SamplingPriority.Set(span, 0)
+	span.Finish()
+}
+
+func TestTraceCounterBounded(t *testing.T) {
+	var c = newTraceCounter(2)
+	var stuck = model.TraceID{Low: 1}
+	c.start(stuck)
+	for x := uint64(2); x < 100; x = x + 1 {
+		var id = model.TraceID{Low: x}
+		if _, evicted := c.start(id); evicted {
+			t.Fatalf("evicted a trace with %d open", len(c.open))
+		}
+		if !c.finish(id) {
+			t.Fatal("the only span of the trace was not the last")
+		}
+	}
+	if len(c.order) > 4 {
+		t.Errorf("completed traces were retained: %d started for %d open", len(c.order), len(c.open))
+	}
+	c.start(model.TraceID{Low: 100})
+	if evicted, ok := c.start(model.TraceID{Low: 101}); !ok || evicted != stuck {
+		t.Errorf("expected the oldest trace to be evicted but got %v", evicted)
+	}
+	if !c.finish(stuck) {
+		t.Error("a span of an evicted trace was not handled as the last of its trace")
+	}
+}
@@ -1,8 +1,11 @@
 package httptrace
 
 import (
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync/atomic"
 
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
@@ -41,34 +44,106 @@ func (c httpHeaderTextMapCarrier) ForeachKey(handler func(key, val string) error
 
 // Transport adds zipkin style request tracing headers to outgoing requests.
 type Transport struct {
-	wrapped   http.RoundTripper
-	spanName  string
-	peerNamer func(*http.Request) string
+	wrapped      http.RoundTripper
+	spanName     string
+	peerNamer    func(*http.Request) string
+	spanFilter   func(*http.Request) bool
+	headerFilter func(*http.Request) bool
+	allowHosts   []string
+	denyHosts    []string
+	errorHandler ErrorHandler
+	parentSpan   func(*http.Request) opentracing.Span
+	retryPolicy  RetryPolicy
 }
 
 // RoundTrip injects zipkin B3 headers into outgoing requests.
 func (c *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if c.retryPolicy != nil {
+		return c.retry(r)
+	}
+	return c.roundTrip(r)
+}
+
+// roundTrip sends a single attempt of the request.
+func (c *Transport) roundTrip(r *http.Request) (*http.Response, error) {
 	var parent = opentracing.SpanFromContext(r.Context())
+	if parent == nil && c.parentSpan != nil {
+		parent = c.parentSpan(r)
+	}
 	if parent == nil {
+		var tracer = GlobalTracer()
+		if tracer == nil || !c.spanFilter(r) {
+			return c.wrapped.RoundTrip(r)
+		}
+		return c.trace(r, tracer.StartSpan(c.spanName))
+	}
+	if !c.spanFilter(r) {
+		if c.injectHeaders(r) {
+			c.inject(r, parent)
+		}
 		return c.wrapped.RoundTrip(r)
 	}
-	var span = parent.Tracer().StartSpan(c.spanName, opentracing.ChildOf(parent.Context()))
+	return c.trace(r, parent.Tracer().StartSpan(c.spanName, opentracing.ChildOf(parent.Context())))
+}
+
+// trace records the outgoing request using the given client span.
+func (c *Transport) trace(r *http.Request, span opentracing.Span) (*http.Response, error) {
 	defer span.Finish()
 	ext.SpanKindRPCClient.Set(span)
 	ext.HTTPMethod.Set(span, r.Method)
 	ext.HTTPUrl.Set(span, r.URL.Path)
 	ext.PeerService.Set(span, c.peerNamer(r))
-	_ = span.Tracer().Inject(span.Context(), opentracing.TextMap, httpHeaderTextMapCarrier(r.Header))
+	tagAttempt(r.Context(), span)
+	tagRedirect(r.Context(), span)
+	var legacy = legacySpan(span)
+	if !legacy {
+		tagDeadline(r.Context(), span)
+	}
+	if c.injectHeaders(r) {
+		c.inject(r, span)
+	}
+	if !legacy {
+		var u *upload
+		if r, u = traceUpload(r, span); u != nil {
+			defer u.finish()
+		}
+	}
 	var resp, er = c.wrapped.RoundTrip(r)
 	if resp != nil {
 		ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
 	}
 	if er != nil {
 		ext.Error.Set(span, true)
+		if !legacy {
+			tagClientError(span, er)
+		}
 	}
 	return resp, er
 }
 
+// inject writes the headers for the given span into the outgoing request and
+// reports any failure to the error handler.
+func (c *Transport) inject(r *http.Request, span opentracing.Span) {
+	var err = span.Tracer().Inject(span.Context(), opentracing.TextMap, httpHeaderTextMapCarrier(r.Header))
+	if err != nil {
+		atomic.AddUint64(&stats.InjectFailures, 1)
+		c.errorHandler(r.Context(), err)
+	}
+}
+
+// injectHeaders reports whether tracing headers should be attached to the
+// request based on the header filter and the host allow and deny lists.
+func (c *Transport) injectHeaders(r *http.Request) bool {
+	if !c.headerFilter(r) {
+		return false
+	}
+	var host = requestHost(r)
+	if matchHosts(c.denyHosts, host) {
+		return false
+	}
+	return len(c.allowHosts) < 1 || matchHosts(c.allowHosts, host)
+}
+
 // TransportOption is a configuration setting for the Transport wrapper.
 type TransportOption func(*Transport) *Transport
 
@@ -102,14 +177,93 @@ func TransportOptionPeerNamer(namer func(*http.Request) string) TransportOption
 	}
 }
 
+// TransportOptionSpanFilter installs a predicate that decides whether a span
+// is recorded for an outgoing request. Requests for which the filter returns
+// false are sent without a span of their own but, subject to the header
+// filter, still carry the headers of the active span so that the trace is not
+// broken. This is useful for noisy calls such as metadata endpoints, token
+// refreshes, or telemetry sinks. The default records a span for all requests.
+func TransportOptionSpanFilter(filter func(*http.Request) bool) TransportOption {
+	return func(t *Transport) *Transport {
+		t.spanFilter = filter
+		return t
+	}
+}
+
+// TransportOptionParentSpan installs a function that finds the parent of the
+// client span for requests whose context contains no opentracing span. The
+// function returns nil when the request has no parent, in which case the
+// global tracer is used as before. This allows requests made from code that
+// uses another tracing library to be recorded within its traces.
+func TransportOptionParentSpan(parent func(*http.Request) opentracing.Span) TransportOption {
+	return func(t *Transport) *Transport {
+		t.parentSpan = parent
+		return t
+	}
+}
+
+// TransportOptionHeaderFilter installs a predicate that decides whether
+// tracing headers are injected into an outgoing request. Requests for which
+// the filter returns false are still recorded as spans, subject to the span
+// filter, but do not carry any trace identifiers or baggage. This can be used
+// to prevent leaking trace data to untrusted third parties. The default
+// injects headers into all requests.
+func TransportOptionHeaderFilter(filter func(*http.Request) bool) TransportOption {
+	return func(t *Transport) *Transport {
+		t.headerFilter = filter
+		return t
+	}
+}
+
+// TransportOptionAllowHosts restricts header injection to requests whose
+// destination host matches one of the given patterns. Patterns are either
+// exact host names, such as api.example.com, or wildcard domains, such as
+// *.example.com, which match any subdomain of example.com but not
+// example.com itself. Matching is case insensitive and ignores ports.
+// Requests to other hosts are still recorded as spans but are sent without
+// tracing headers. By default, headers are sent to all hosts.
+func TransportOptionAllowHosts(hosts ...string) TransportOption {
+	return func(t *Transport) *Transport {
+		t.allowHosts = append(t.allowHosts, normalizeHosts(hosts)...)
+		return t
+	}
+}
+
+// TransportOptionDenyHosts prevents header injection for requests whose
+// destination host matches one of the given patterns. Patterns follow the
+// same rules as TransportOptionAllowHosts and the deny list always takes
+// precedence over the allow list. Requests to denied hosts are still
+// recorded as spans.
+func TransportOptionDenyHosts(hosts ...string) TransportOption {
+	return func(t *Transport) *Transport {
+		t.denyHosts = append(t.denyHosts, normalizeHosts(hosts)...)
+		return t
+	}
+}
+
+// TransportOptionErrorHandler sets the function that receives errors from the
+// tracing infrastructure, such as a failure to inject trace headers. Requests
+// are always sent regardless of these errors. The default handler logs the
+// first error it receives using the logevent.Logger contained within the
+// request context.
+func TransportOptionErrorHandler(handler ErrorHandler) TransportOption {
+	return func(t *Transport) *Transport {
+		t.errorHandler = handler
+		return t
+	}
+}
+
 // NewTransport creats an http.RoundTripper wrapper that injects zipkin
 // headers into all outgoing requests.
 func NewTransport(options ...TransportOption) func(c http.RoundTripper) http.RoundTripper {
 	return func(c http.RoundTripper) http.RoundTripper {
 		var wrapper = &Transport{
-			spanName:  "OutgoingHTTPRequest",
-			peerNamer: func(*http.Request) string { return "dependency" },
-			wrapped:   c,
+			spanName:     "OutgoingHTTPRequest",
+			peerNamer:    func(*http.Request) string { return "dependency" },
+			spanFilter:   allRequests,
+			headerFilter: allRequests,
+			errorHandler: LogErrorOnce(),
+			wrapped:      c,
 		}
 		for _, option := range options {
 			wrapper = option(wrapper)
@@ -117,3 +271,45 @@ func NewTransport(options ...TransportOption) func(c http.RoundTripper) http.Rou
 		return wrapper
 	}
 }
+
+func allRequests(*http.Request) bool {
+	return true
+}
+
+// requestHost returns the lower cased destination host of an outgoing request
+// without any port.
+func requestHost(r *http.Request) string {
+	var host = r.URL.Host
+	if host == "" {
+		host = r.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+func normalizeHosts(hosts []string) []string {
+	var result = make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		result = append(result, strings.ToLower(strings.TrimSpace(host)))
+	}
+	return result
+}
+
+// matchHosts reports whether the host matches any of the patterns. A pattern
+// beginning with *. matches any subdomain of the remaining domain.
+func matchHosts(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+			continue
+		}
+		if pattern == host {
+			return true
+		}
+	}
+	return false
+}
@@ -24,6 +24,13 @@ func (c *fixtureTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 	return c.Response, c.Err
 }
 
+// contextWithMockSpan installs a mock span in a context. The opentracing
+// package inspects the tracer of the span when doing so.
+func contextWithMockSpan(span *mockSpan, tracer *mockTracer) context.Context {
+	span.EXPECT().Tracer().Return(tracer)
+	return opentracing.ContextWithSpan(context.Background(), span)
+}
+
 func TestTraceNoopIfNoParent(t *testing.T) {
 	var ctrl = gomock.NewController(t)
 	defer ctrl.Finish()
@@ -96,7 +103,7 @@ func TestTraceAdoptsSpanIfExists(t *testing.T) {
 	var parentSpanContext = newMockSpanContext(ctrl)
 	var childSpan = newMockSpan(ctrl)
 	var childSpanContext = newMockSpanContext(ctrl)
-	var ctx = opentracing.ContextWithSpan(context.Background(), parentSpan)
+	var ctx = contextWithMockSpan(parentSpan, tracer)
 	var wrapped = NewTransport(
 		TransportOptionPeerName("TESTPATH"),
 		TransportOptionSpanName("TESTSPAN"),
@@ -131,7 +138,7 @@ func TestTraceAdoptsSpanIfExistsFailure(t *testing.T) {
 	var parentSpanContext = newMockSpanContext(ctrl)
 	var childSpan = newMockSpan(ctrl)
 	var childSpanContext = newMockSpanContext(ctrl)
-	var ctx = opentracing.ContextWithSpan(context.Background(), parentSpan)
+	var ctx = contextWithMockSpan(parentSpan, tracer)
 	var wrapped = NewTransport(
 		TransportOptionPeerName("TESTPATH"),
 		TransportOptionSpanName("TESTSPAN"),
@@ -149,6 +156,8 @@ func TestTraceAdoptsSpanIfExistsFailure(t *testing.T) {
 	childSpan.EXPECT().Context().Return(childSpanContext)
 	tracer.EXPECT().Inject(childSpanContext, opentracing.TextMap, gomock.Any())
 	childSpan.EXPECT().SetTag(string(ext.Error), true)
+	childSpan.EXPECT().SetTag(errorKindTag, errorKindOther)
+	childSpan.EXPECT().SetTag(errorMessageTag, "")
 	childSpan.EXPECT().Finish()
 	_, _ = wrapped.RoundTrip(req.WithContext(ctx))
 }
@@ -196,3 +205,155 @@ func TestHeaderCarrierError(t *testing.T) {
 		t.Fatal("did not propagate error values")
 	}
 }
+
+func TestTraceSpanFilterPropagatesHeaders(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var tracer = newMockTracer(ctrl)
+	var parentSpan = newMockSpan(ctrl)
+	var parentSpanContext = newMockSpanContext(ctrl)
+	var ctx = contextWithMockSpan(parentSpan, tracer)
+	var wrapped = NewTransport(
+		TransportOptionSpanFilter(func(*http.Request) bool { return false }),
+	)(&fixtureTransport{Response: nil, Err: nil})
+	var req, _ = http.NewRequest(http.MethodGet, "/", nil)
+
+	parentSpan.EXPECT().Tracer().Return(tracer)
+	parentSpan.EXPECT().Context().Return(parentSpanContext)
+	tracer.EXPECT().Inject(parentSpanContext, opentracing.TextMap, gomock.Any())
+	_, _ = wrapped.RoundTrip(req.WithContext(ctx))
+}
+
+func TestTraceSpanAndHeaderFilter(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var tracer = newMockTracer(ctrl)
+	var parentSpan = newMockSpan(ctrl)
+	var ctx = contextWithMockSpan(parentSpan, tracer)
+	var wrapped = NewTransport(
+		TransportOptionSpanFilter(func(*http.Request) bool { return false }),
+		TransportOptionHeaderFilter(func(*http.Request) bool { return false }),
+	)(&fixtureTransport{Response: nil, Err: nil})
+	var req, _ = http.NewRequest(http.MethodGet, "/", nil)
+
+	_, _ = wrapped.RoundTrip(req.WithContext(ctx))
+}
+
+func TestTraceHeaderFilterRecordsSpan(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var tracer = newMockTracer(ctrl)
+	var parentSpan = newMockSpan(ctrl)
+	var parentSpanContext = newMockSpanContext(ctrl)
+	var childSpan = newMockSpan(ctrl)
+	var ctx = contextWithMockSpan(parentSpan, tracer)
+	var resp = http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	var wrapped = NewTransport(
+		TransportOptionPeerName("TESTPATH"),
+		TransportOptionSpanName("TESTSPAN"),
+		TransportOptionHeaderFilter(func(*http.Request) bool { return false }),
+	)(&fixtureTransport{Response: &resp, Err: nil})
+	var req, _ = http.NewRequest(http.MethodGet, "/", nil)
+
+	parentSpan.EXPECT().Tracer().Return(tracer)
+	parentSpan.EXPECT().Context().Return(parentSpanContext)
+	tracer.EXPECT().StartSpan("TESTSPAN", opentracing.ChildOf(parentSpanContext)).Return(childSpan)
+	childSpan.EXPECT().SetTag(ext.SpanKindRPCClient.Key, ext.SpanKindRPCClient.Value)
+	childSpan.EXPECT().SetTag(string(ext.HTTPMethod), http.MethodGet)
+	childSpan.EXPECT().SetTag(string(ext.HTTPUrl), "/")
+	childSpan.EXPECT().SetTag(string(ext.PeerService), "TESTPATH")
+	childSpan.EXPECT().SetTag(string(ext.HTTPStatusCode), uint16(http.StatusOK))
+	childSpan.EXPECT().Finish()
+	_, _ = wrapped.RoundTrip(req.WithContext(ctx))
+}
+
+func TestTransportHostLists(t *testing.T) {
+	var tests = []struct {
+		name   string
+		url    string
+		allow  []string
+		deny   []string
+		inject bool
+	}{
+		{name: "no lists", url: "http://example.com/", inject: true},
+		{name: "allowed exact", url: "http://api.internal:8080/", allow: []string{"api.internal"}, inject: true},
+		{name: "not allowed", url: "http://vendor.com/", allow: []string{"api.internal"}, inject: false},
+		{name: "allowed wildcard", url: "http://a.b.internal/", allow: []string{"*.internal"}, inject: true},
+		{name: "wildcard excludes apex", url: "http://internal/", allow: []string{"*.internal"}, inject: false},
+		{name: "denied wildcard", url: "http://API.Vendor.com/", deny: []string{"*.vendor.com"}, inject: false},
+		{name: "deny precedence", url: "http://api.internal/", allow: []string{"*.internal"}, deny: []string{"api.internal"}, inject: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wrapped = NewTransport(
+				TransportOptionAllowHosts(tt.allow...),
+				TransportOptionDenyHosts(tt.deny...),
+			)(&fixtureTransport{}).(*Transport)
+			var req, _ = http.NewRequest(http.MethodGet, tt.url, nil)
+			if wrapped.injectHeaders(req) != tt.inject {
+				t.Fatalf("expected inject=%t for %s", tt.inject, tt.url)
+			}
+		})
+	}
+}
+
+func TestTraceReportsInjectErrors(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var tracer = newMockTracer(ctrl)
+	var parentSpan = newMockSpan(ctrl)
+	var parentSpanContext = newMockSpanContext(ctrl)
+	var ctx = contextWithMockSpan(parentSpan, tracer)
+	var handlerErr error
+	var wrapped = NewTransport(
+		TransportOptionSpanFilter(func(*http.Request) bool { return false }),
+		TransportOptionErrorHandler(func(_ context.Context, err error) {
+			handlerErr = err
+		}),
+	)(&fixtureTransport{Response: nil, Err: nil})
+	var req, _ = http.NewRequest(http.MethodGet, "/", nil)
+
+	parentSpan.EXPECT().Tracer().Return(tracer)
+	parentSpan.EXPECT().Context().Return(parentSpanContext)
+	tracer.EXPECT().Inject(parentSpanContext, opentracing.TextMap, gomock.Any()).Return(opentracing.ErrInvalidCarrier)
+	_, _ = wrapped.RoundTrip(req.WithContext(ctx))
+	if handlerErr != opentracing.ErrInvalidCarrier {
+		t.Errorf("expected inject error to be reported but got %v", handlerErr)
+	}
+}
+
+func TestTransportParentSpan(t *testing.T) {
+	var recorder = NewRecorder()
+	var tracer, _ = NewTracer(nil, "client", "127.0.0.1:8080", TracerOptionReporter(recorder))
+	var parent = tracer.StartSpan("parent")
+	var downstream = &fixtureTransport{Response: &http.Response{StatusCode: http.StatusOK}}
+	var wrapped = NewTransport(
+		TransportOptionSpanName("TESTSPAN"),
+		TransportOptionParentSpan(func(r *http.Request) opentracing.Span {
+			if r.Header.Get("X-Parent") == "" {
+				return nil
+			}
+			return parent
+		}),
+	)(downstream)
+
+	var req, _ = http.NewRequest(http.MethodGet, "/", nil)
+	_, _ = wrapped.RoundTrip(req)
+	req, _ = http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Parent", "true")
+	_, _ = wrapped.RoundTrip(req)
+	parent.Finish()
+
+	var spans = recorder.SpansNamed("TESTSPAN")
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 client span but got %d", len(spans))
+	}
+	recorder.AssertChildOf(t, spans[0], recorder.SpansNamed("parent")[0])
+	if downstream.Request.Header.Get("X-B3-SpanId") != spans[0].ID.String() {
+		t.Errorf("client span was not propagated: %v", downstream.Request.Header)
+	}
+}
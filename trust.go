@@ -0,0 +1,189 @@
+package httptrace
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+const (
+	b3TraceIDHeader      = "X-B3-TraceId"
+	b3SpanIDHeader       = "X-B3-SpanId"
+	b3ParentSpanIDHeader = "X-B3-ParentSpanId"
+	b3SampledHeader      = "X-B3-Sampled"
+	b3FlagsHeader        = "X-B3-Flags"
+	b3SingleHeader       = "B3"
+
+	untrustedTraceIDTag = "untrusted.trace_id"
+	untrustedSpanIDTag  = "untrusted.span_id"
+
+	// signatureMaxAge bounds how long a signature may be replayed. It also
+	// allows for clock skew between the signing and the verifying host.
+	signatureMaxAge = 5 * time.Minute
+)
+
+// TrustPolicy decides whether the trace headers of an incoming request can be
+// used to continue the trace.
+type TrustPolicy func(*http.Request) bool
+
+// TrustPolicyAll trusts the trace headers of every request.
+func TrustPolicyAll(*http.Request) bool {
+	return true
+}
+
+// TrustPolicyNone never trusts the trace headers of incoming requests and
+// always starts a new trace.
+func TrustPolicyNone(*http.Request) bool {
+	return false
+}
+
+// TrustPolicyCIDR trusts requests whose remote address is contained in any of
+// the given CIDR ranges. The address is taken from the RemoteAddr of the
+// request and not from any forwarding headers, which clients can forge.
+func TrustPolicyCIDR(cidrs ...string) (TrustPolicy, error) {
+	var networks = make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		var _, network, err = net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return func(r *http.Request) bool {
+		var host, _, err = net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		var ip = net.ParseIP(host)
+		if ip == nil {
+			return false
+		}
+		for _, network := range networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// TrustPolicyHeader trusts requests that contain a non-empty value for the
+// given header. This is intended for use with headers that are set by an
+// internal authentication layer and stripped from external traffic.
+func TrustPolicyHeader(name string) TrustPolicy {
+	return func(r *http.Request) bool {
+		return r.Header.Get(name) != ""
+	}
+}
+
+// TrustPolicySignedHeader trusts requests that carry a valid signature of
+// their trace headers in the given header. Signatures are produced with
+// SignTraceHeaders using the same key. Every header from which the trace is
+// extracted, including the single b3 header, the sampling and debug flags, and
+// the baggage headers, is covered by the signature so that none of them can be
+// added or changed without invalidating it. Signatures include the time at
+// which they were made and are rejected once they are more than five minutes
+// old or ahead of the local clock, which bounds how long a captured signature
+// can be replayed.
+func TrustPolicySignedHeader(name string, key []byte) TrustPolicy {
+	return func(r *http.Request) bool {
+		var stamp, encoded, ok = strings.Cut(r.Header.Get(name), ":")
+		if !ok {
+			return false
+		}
+		var unix, err = strconv.ParseInt(stamp, 10, 64)
+		if err != nil {
+			return false
+		}
+		var age = time.Since(time.Unix(unix, 0))
+		if age > signatureMaxAge || age < -signatureMaxAge {
+			return false
+		}
+		signature, err := hex.DecodeString(encoded)
+		if err != nil || len(signature) < 1 {
+			return false
+		}
+		return hmac.Equal(signature, traceHeaderMAC(r.Header, stamp, key))
+	}
+}
+
+// TrustPolicyAny trusts requests that are trusted by at least one of the given
+// policies.
+func TrustPolicyAny(policies ...TrustPolicy) TrustPolicy {
+	return func(r *http.Request) bool {
+		for _, policy := range policies {
+			if policy(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SignTraceHeaders sets the named header to a signature of the trace headers
+// contained in the given headers. It must be called after the trace headers
+// are injected, such as from a transport wrapped by Transport.
+func SignTraceHeaders(header http.Header, name string, key []byte) {
+	signTraceHeaders(header, name, key, time.Now())
+}
+
+func signTraceHeaders(header http.Header, name string, key []byte, now time.Time) {
+	var stamp = strconv.FormatInt(now.Unix(), 10)
+	header.Set(name, stamp+":"+hex.EncodeToString(traceHeaderMAC(header, stamp, key)))
+}
+
+// signedHeaders are the headers used by the tracer to extract the span context
+// of an incoming request.
+var signedHeaders = []string{
+	b3SingleHeader,
+	b3TraceIDHeader,
+	b3SpanIDHeader,
+	b3ParentSpanIDHeader,
+	b3SampledHeader,
+	b3FlagsHeader,
+}
+
+// traceHeaderMAC signs the timestamp and every value of each of the signed
+// headers and of the baggage headers. Absent headers are signed as empty so
+// that adding one later breaks the signature and values are prefixed with their
+// length so that they cannot be split or joined differently.
+func traceHeaderMAC(header http.Header, stamp string, key []byte) []byte {
+	var mac = hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(strconv.Itoa(len(stamp)) + ":" + stamp + "\n"))
+	var writeValues = func(name string, values []string) {
+		_, _ = mac.Write([]byte(name + ":" + strconv.Itoa(len(values)) + "\n"))
+		for _, value := range values {
+			_, _ = mac.Write([]byte(strconv.Itoa(len(value)) + ":" + value + "\n"))
+		}
+	}
+	for _, name := range signedHeaders {
+		writeValues(name, header.Values(name))
+	}
+	var baggage []string
+	for name := range header {
+		if strings.HasPrefix(strings.ToLower(name), baggageHeaderPrefix) {
+			baggage = append(baggage, name)
+		}
+	}
+	sort.Strings(baggage)
+	for _, name := range baggage {
+		writeValues(name, header[name])
+	}
+	return mac.Sum(nil)
+}
+
+// tagUntrustedParent records the identifiers of an untrusted parent span on
+// the given span.
+func tagUntrustedParent(span opentracing.Span, parent opentracing.SpanContext) {
+	var traceID, spanID = spanContextIDs(span.Tracer(), parent)
+	span.SetTag(untrustedTraceIDTag, traceID)
+	span.SetTag(untrustedSpanIDTag, spanID)
+}
@@ -0,0 +1,175 @@
+package httptrace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrustPolicyCIDR(t *testing.T) {
+	var policy, err = TrustPolicyCIDR("10.0.0.0/8", "fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		remote  string
+		trusted bool
+	}{
+		{remote: "10.1.2.3:1234", trusted: true},
+		{remote: "[fd00::1]:1234", trusted: true},
+		{remote: "192.168.1.1:1234", trusted: false},
+		{remote: "10.1.2.3", trusted: true},
+		{remote: "", trusted: false},
+	}
+	for _, tt := range tests {
+		var r, _ = http.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		if policy(r) != tt.trusted {
+			t.Errorf("expected trusted=%t for %q", tt.trusted, tt.remote)
+		}
+	}
+	if _, err = TrustPolicyCIDR("not-a-cidr"); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+}
+
+func TestTrustPolicySignedHeader(t *testing.T) {
+	var key = []byte("secret")
+	var policy = TrustPolicySignedHeader("X-Trace-Signature", key)
+	var r, _ = http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(b3TraceIDHeader, "0000000000000001")
+	r.Header.Set(b3SpanIDHeader, "0000000000000002")
+	if policy(r) {
+		t.Fatal("trusted a request without a signature")
+	}
+	SignTraceHeaders(r.Header, "X-Trace-Signature", key)
+	if !policy(r) {
+		t.Fatal("did not trust a correctly signed request")
+	}
+	r.Header.Set(b3TraceIDHeader, "0000000000000003")
+	if policy(r) {
+		t.Fatal("trusted a request with a tampered trace id")
+	}
+	SignTraceHeaders(r.Header, "X-Trace-Signature", []byte("other"))
+	if policy(r) {
+		t.Fatal("trusted a request signed with the wrong key")
+	}
+}
+
+func TestTrustPolicySignedHeaderCoversExtractedHeaders(t *testing.T) {
+	var key = []byte("secret")
+	var policy = TrustPolicySignedHeader("X-Trace-Signature", key)
+	var signed = func() *http.Request {
+		var r, _ = http.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(b3TraceIDHeader, "0000000000000001")
+		r.Header.Set(b3SpanIDHeader, "0000000000000002")
+		r.Header.Set(b3SampledHeader, "0")
+		r.Header.Set("ot-baggage-tenant", "example")
+		SignTraceHeaders(r.Header, "X-Trace-Signature", key)
+		return r
+	}
+	if !policy(signed()) {
+		t.Fatal("did not trust a correctly signed request")
+	}
+	var tests = []struct {
+		name   string
+		tamper func(http.Header)
+	}{
+		{"single header", func(h http.Header) { h.Set("b3", "00000000000000aa-00000000000000bb-d") }},
+		{"sampled", func(h http.Header) { h.Set(b3SampledHeader, "1") }},
+		{"flags", func(h http.Header) { h.Set(b3FlagsHeader, "1") }},
+		{"parent", func(h http.Header) { h.Set(b3ParentSpanIDHeader, "0000000000000003") }},
+		{"duplicate trace", func(h http.Header) { h.Add(b3TraceIDHeader, "00000000000000aa") }},
+		{"added baggage", func(h http.Header) { h.Set("ot-baggage-user", "admin") }},
+		{"changed baggage", func(h http.Header) { h.Set("ot-baggage-tenant", "other") }},
+		{"removed baggage", func(h http.Header) { h.Del("ot-baggage-tenant") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r = signed()
+			tt.tamper(r.Header)
+			if policy(r) {
+				t.Error("trusted a request with a header added after signing")
+			}
+		})
+	}
+}
+
+func TestTrustPolicySignedHeaderReplay(t *testing.T) {
+	var key = []byte("secret")
+	var policy = TrustPolicySignedHeader("X-Trace-Signature", key)
+	var tests = []struct {
+		name    string
+		signed  time.Time
+		trusted bool
+	}{
+		{"recent", time.Now().Add(-time.Minute), true},
+		{"stale", time.Now().Add(-signatureMaxAge - time.Minute), false},
+		{"future", time.Now().Add(signatureMaxAge + time.Minute), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r, _ = http.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(b3TraceIDHeader, "0000000000000001")
+			r.Header.Set(b3SpanIDHeader, "0000000000000002")
+			signTraceHeaders(r.Header, "X-Trace-Signature", key, tt.signed)
+			if policy(r) != tt.trusted {
+				t.Errorf("expected trusted=%t", tt.trusted)
+			}
+		})
+	}
+
+	var r, _ = http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(b3TraceIDHeader, "0000000000000001")
+	SignTraceHeaders(r.Header, "X-Trace-Signature", key)
+	var _, signature, _ = strings.Cut(r.Header.Get("X-Trace-Signature"), ":")
+	var changed = strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+	r.Header.Set("X-Trace-Signature", changed+":"+signature)
+	if policy(r) {
+		t.Error("trusted a signature whose timestamp was changed")
+	}
+	r.Header.Set("X-Trace-Signature", signature)
+	if policy(r) {
+		t.Error("trusted a signature without a timestamp")
+	}
+}
+
+func TestMiddlewareRejectsForgedSingleHeader(t *testing.T) {
+	var key = []byte("secret")
+	var recorder = NewRecorder()
+	var handler = NewMiddleware(
+		MiddlewareOptionServiceName("testservice"),
+		MiddlewareOptionTracerOptions(TracerOptionReporter(recorder)),
+		MiddlewareOptionTrustPolicy(TrustPolicySignedHeader("X-Trace-Signature", key)),
+	)(&fixtureHandler{})
+	var r, _ = http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(b3TraceIDHeader, "0000000000000001")
+	r.Header.Set(b3SpanIDHeader, "0000000000000002")
+	SignTraceHeaders(r.Header, "X-Trace-Signature", key)
+	// A single b3 header takes precedence during extraction.
+	r.Header.Set("b3", "00000000000000aa-00000000000000bb-d")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	var span = recorder.SpansNamed("testservice")[0]
+	if span.TraceID.String() == "00000000000000aa" || span.ParentID != nil {
+		t.Error("joined a trace from a header that was not signed")
+	}
+	if span.Debug {
+		t.Error("enabled debug from a header that was not signed")
+	}
+}
+
+func TestTrustPolicyAny(t *testing.T) {
+	var policy = TrustPolicyAny(TrustPolicyNone, TrustPolicyHeader("X-Internal-Auth"))
+	var r, _ = http.NewRequest(http.MethodGet, "/", nil)
+	if policy(r) {
+		t.Fatal("trusted a request without the header")
+	}
+	r.Header.Set("X-Internal-Auth", "token")
+	if !policy(r) {
+		t.Fatal("did not trust a request with the header")
+	}
+}
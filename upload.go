@@ -0,0 +1,86 @@
+package httptrace
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+const (
+	requestSizeTag      = "http.request_size"
+	uploadCompleteEvent = "upload-complete"
+)
+
+// upload records the transmission of the body of an outgoing request on its
+// client span. The body may be read by the wrapped transport after the
+// response is received, or not at all, so nothing is recorded once the span
+// has been finished.
+type upload struct {
+	span     opentracing.Span
+	lock     sync.Mutex
+	sent     int64
+	finished bool
+}
+
+// traceUpload replaces the body of the request, and the function that
+// recreates it, with bodies that record the upload. The original request is
+// not modified.
+func traceUpload(r *http.Request, span opentracing.Span) (*http.Request, *upload) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return r, nil
+	}
+	var u = &upload{span: span}
+	var req = new(http.Request)
+	*req = *r
+	req.Body = &uploadBody{ReadCloser: r.Body, upload: u}
+	if r.GetBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			var body, err = r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			// The body is being sent again from the beginning.
+			u.lock.Lock()
+			u.sent = 0
+			u.lock.Unlock()
+			return &uploadBody{ReadCloser: body, upload: u}, nil
+		}
+	}
+	return req, u
+}
+
+func (u *upload) read(n int, err error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.finished {
+		return
+	}
+	u.sent = u.sent + int64(n)
+	if err == io.EOF {
+		u.span.LogFields(log.String("event", uploadCompleteEvent), log.Int64("bytes", u.sent))
+	}
+}
+
+// finish tags the span with the number of bytes sent so far and stops
+// recording.
+func (u *upload) finish() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.finished = true
+	u.span.SetTag(requestSizeTag, u.sent)
+}
+
+// uploadBody counts the bytes read from the body of a request.
+type uploadBody struct {
+	io.ReadCloser
+	upload *upload
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	var n, err = b.ReadCloser.Read(p)
+	b.upload.read(n, err)
+	return n, err
+}
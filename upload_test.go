@@ -0,0 +1,88 @@
+package httptrace
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTransportTracesUpload(t *testing.T) {
+	var received string
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body, _ = io.ReadAll(r.Body)
+		received = string(body)
+	}))
+	defer server.Close()
+
+	var recorder = NewRecorder()
+	var tracer, _ = NewTracer(nil, "client", "127.0.0.1:8080", TracerOptionReporter(recorder))
+	var parent = tracer.StartSpan("parent")
+	var client = &http.Client{Transport: NewTransport(TransportOptionSpanName("TESTSPAN"))(http.DefaultTransport)}
+	var r, _ = http.NewRequest(http.MethodPost, server.URL, strings.NewReader("uploaded body"))
+	var resp, err = client.Do(r.WithContext(ContextWithSpan(context.Background(), parent)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	parent.Finish()
+
+	if received != "uploaded body" {
+		t.Errorf("server received %q", received)
+	}
+	var spans = recorder.SpansNamed("TESTSPAN")
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 client span but got %d", len(spans))
+	}
+	recorder.AssertTag(t, spans[0], requestSizeTag, "13")
+	var completed bool
+	for _, annotation := range spans[0].Annotations {
+		if strings.Contains(annotation.Value, uploadCompleteEvent) && strings.Contains(annotation.Value, "bytes=13") {
+			completed = true
+		}
+	}
+	if !completed {
+		t.Errorf("client span is missing the upload completion in %v", spans[0].Annotations)
+	}
+}
+
+func TestTraceUploadGetBody(t *testing.T) {
+	var recorder = NewRecorder()
+	var tracer, _ = NewTracer(nil, "client", "127.0.0.1:8080", TracerOptionReporter(recorder))
+	var span = tracer.StartSpan("TESTSPAN")
+	var r, _ = http.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader("body"))
+
+	var req, u = traceUpload(r, span)
+	if req == r {
+		t.Fatal("modified the original request")
+	}
+	if _, ok := r.Body.(*uploadBody); ok {
+		t.Fatal("replaced the body of the original request")
+	}
+	_, _ = io.ReadAll(req.Body)
+	var body, err = req.GetBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data, _ = io.ReadAll(body)
+	if string(data) != "body" {
+		t.Errorf("GetBody returned %q", data)
+	}
+	u.finish()
+	// Reads after the span is finished are not recorded.
+	body, _ = req.GetBody()
+	_, _ = io.ReadAll(body)
+	span.Finish()
+
+	var spans = recorder.SpansNamed("TESTSPAN")
+	recorder.AssertTag(t, spans[0], requestSizeTag, "4")
+	if len(spans[0].Annotations) != 2 {
+		t.Errorf("expected an upload completion for each body but got %v", spans[0].Annotations)
+	}
+
+	if req, u = traceUpload(&http.Request{Body: http.NoBody}, span); u != nil || req.Body != http.NoBody {
+		t.Error("traced a request without a body")
+	}
+}